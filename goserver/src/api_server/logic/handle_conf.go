@@ -19,17 +19,17 @@ func HandleConfInfo(c *gin.Context) {
 
 	req.Id, err = strconv.ParseInt(id, 10, 64)
 	if err != nil {
-		tlog.Error(err)
+		tlog.ErrorCtx(project.LogCtx(c), err)
 		c.JSON(http.StatusOK, project.Fail(defs.ErrCommon, "grpc error:"+err.Error()))
 		return
 	}
 
-	ctx, cancel := discovery.ContextWithStandard()
+	ctx, cancel := discovery.ContextWithParent(project.LogCtx(c))
 	defer cancel()
 	resp, err := ThisServer.ConfigGrpc.Info(ctx, req)
 
 	if err != nil {
-		tlog.Error(err)
+		tlog.ErrorCtx(ctx, err)
 		ThisServer.Output.OutputGrpcError(c, err)
 	} else {
 		ThisServer.Output.OutputSuccess(c, resp)
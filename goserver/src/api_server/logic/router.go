@@ -1,6 +1,7 @@
 package logic
 
 import (
+	"common/project"
 	"github.com/gin-gonic/gin"
 	"net/http"
 )
@@ -9,7 +10,7 @@ func GetHttpHandler() http.Handler {
 	gin.SetMode(gin.ReleaseMode)
 	e := gin.New()
 	//中间件
-	e.Use(httpPreprocess)
+	e.Use(project.LogContext(), httpPreprocess)
 
 	apiRouter := e.Group("/api")
 	{
@@ -24,5 +25,6 @@ func GetHttpHandler() http.Handler {
 }
 
 func httpPreprocess(c *gin.Context) {
+	//登录校验通过后调用 project.SetLogUid, 日志与 feature.Gin 按该 uid 计算
 	c.Next()
 }
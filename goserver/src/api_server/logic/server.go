@@ -1,29 +1,23 @@
 package logic
 
 import (
+	"common/app"
 	"common/discovery"
-	"common/metrics"
 	"common/proto/config"
-	"common/tlog"
 	"common/util"
+	"context"
 	"database/sql"
-	"fmt"
-	"github.com/facebookgo/grace/gracehttp"
 	"github.com/go-redis/redis"
 	"gorm.io/gorm"
 	"net/http"
-	"time"
 )
 
 type Config struct {
-	Host     string           `toml:"server_host"`
+	app.Config
+	Host     string           `toml:"server_host" validate:"required"`
 	ServerId int              `toml:"server_id"`
-	Log      tlog.Config      `toml:"Log"`
 	Redis    util.RedisConfig `toml:"Redis"`
 	Db       util.MysqlConfig `toml:"Db"`
-	Etcd     []string         `toml:"etcd"`
-	Env      string           `toml:"env"`
-	EtcdEnv  string           `toml:"etcd_env"`
 }
 
 type Server struct {
@@ -39,44 +33,44 @@ type Server struct {
 
 var ThisServer *Server
 
-func NewServer(c *Config) error {
-	serverName := "api"
-	metrics.Init(serverName, c.Env)
-
-	cacheRedis, err := util.NewRedisClient(&c.Redis)
-	if err != nil {
-		tlog.Fatal(err)
-		return err
-	}
-	db, err := util.NewMysql(&c.Db)
-	if err != nil {
-		tlog.Fatal(err)
-		return err
-	}
-
-	grpcEnv := c.EtcdEnv
-	configGrpc := discovery.ResolverConfigServer(grpcEnv)
-	gormDB, err := util.NewGormDB(db)
-	if err != nil {
-		tlog.Fatal(err)
-		return err
+//NewServer 注册 api 服务的组件, 由 app.Run 按顺序启动
+func NewServer(a *app.App, c *Config) {
+	var db *sql.DB
+	var cacheRedis *redis.Client
+	//未配置 etcd 时不启用配置中心
+	if len(c.Etcd) > 0 {
+		a.Add(a.ConfCenter())
 	}
-
-	ThisServer = &Server{
-		Env:           c.Env,
-		EtcdEnv:       c.EtcdEnv,
-		Mysql:         db,
-		GormDB:        gormDB,
-		CacheRedis:    cacheRedis,
-		Output:        util.NewHttpOutput(),
-		JsonMarshaler: util.NewJsonMarshaler(),
-		ConfigGrpc:    configGrpc,
-	}
-
-	handler := GetHttpHandler()
-	fmt.Println(util.FormatFullTime(time.Now()), "running ...")
-
-	return gracehttp.Serve(&http.Server{Addr: c.Host, Handler: handler})
+	a.Add(
+		app.Features(),
+		app.Redis("cache", &c.Redis, &cacheRedis),
+		app.Mysql("main", &c.Db, &db),
+		&app.Component{
+			Name: "server",
+			Init: func(ctx context.Context) error {
+				gormDB, err := util.NewGormDB(db)
+				if err != nil {
+					return err
+				}
+				ThisServer = &Server{
+					Env:           c.Env,
+					EtcdEnv:       c.EtcdEnv,
+					Mysql:         db,
+					GormDB:        gormDB,
+					CacheRedis:    cacheRedis,
+					Output:        util.NewHttpOutput(),
+					JsonMarshaler: util.NewJsonMarshaler(),
+					ConfigGrpc:    discovery.ResolverConfigServer(c.EtcdEnv),
+				}
+				return nil
+			},
+			Stop: func(ctx context.Context) error {
+				DestroyServer()
+				return nil
+			},
+		},
+		a.HTTPServer("api", &http.Server{Addr: c.Host, Handler: GetHttpHandler()}),
+	)
 }
 
 func DestroyServer() {
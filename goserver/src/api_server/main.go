@@ -2,39 +2,25 @@ package main
 
 import (
 	"api_server/logic"
-	"common/discovery"
-	"common/tlog"
+	"common/app"
 	"common/util"
 	"fmt"
-	"math/rand"
-	"runtime"
-	"time"
+	"os"
 )
 
 func main() {
 	var c logic.Config
-	if !util.NewConfig("./api-dev.toml", &c) {
-		return
+	//API_CONFIG_ENV=prod 时叠加 api-prod.toml, 环境变量 API_DB_PWD 等覆盖配置文件, 见 util.ConfigLoader
+	loader := &util.ConfigLoader{Path: "./api-dev.toml", EnvPrefix: "API_"}
+	if err := loader.Load(&c); err != nil {
+		fmt.Printf("config load failed: %s\n%s\n", loader.Path, err.Error())
+		os.Exit(2)
 	}
-	tlog.Init(c.Log)
 
-	if c.EtcdEnv == "" {
-		c.EtcdEnv = c.Env
-	}
-	discovery.Init(c.Etcd...)
-	//go func() {
-	//	tlog.Info(http.ListenAndServe("0.0.0.0:32123", nil)) //火焰图
-	//}()
-
-	runtime.GOMAXPROCS(runtime.NumCPU())
-	rand.Seed(time.Now().UnixNano())
-
-	if err := logic.NewServer(&c); err == nil {
-		logic.DestroyServer()
-	} else {
+	a := app.New("api", &c.Config, &c)
+	logic.NewServer(a, &c)
+	if err := a.Run(); err != nil {
 		fmt.Println(err)
+		os.Exit(1)
 	}
-
-	discovery.Close()
-	tlog.Close()
 }
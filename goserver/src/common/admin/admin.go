@@ -0,0 +1,198 @@
+package admin
+
+//管理端口, 提供 pprof, 健康检查, 版本, 配置, 指标, 日志级别与服务发现状态
+//仅用于运维操作, 请绑定内网或本机地址
+
+import (
+	"common/discovery"
+	"common/metrics"
+	"common/tlog"
+	"common/util"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Config 管理端口配置, addr 为空时不启动
+type Config struct {
+	Addr  string `toml:"addr"`
+	Pprof bool   `toml:"pprof"` //开启 /debug/pprof/, 生产环境按需打开
+}
+
+//版本信息, 编译时注入, eg. go build -ldflags "-X common/admin.Version=1.2.0 -X common/admin.Commit=$(git rev-parse HEAD)"
+var (
+	Version   string
+	Commit    string
+	BuildTime string
+)
+
+var (
+	startTime = time.Now()
+	ready     int32
+
+	checkLock sync.Mutex
+	checks    = map[string]func() error{}
+
+	server *http.Server
+)
+
+//AddCheck 注册就绪检查, 同名的会被替换, /ready 每次请求都会执行所有检查
+func AddCheck(name string, check func() error) {
+	checkLock.Lock()
+	checks[name] = check
+	checkLock.Unlock()
+}
+
+//SetReady 启动完成后设为 true, 开始关闭时设为 false, 负载均衡据此摘除流量
+func SetReady(b bool) {
+	if b {
+		atomic.StoreInt32(&ready, 1)
+	} else {
+		atomic.StoreInt32(&ready, 0)
+	}
+}
+
+//Listen 创建管理端口的监听, app 替换为平滑重启时可继承的监听
+var Listen = net.Listen
+
+//Start 启动管理端口, conf 为服务的完整配置, 由 /config 输出, 敏感字段会被遮蔽
+func Start(c Config, conf interface{}) error {
+	if c.Addr == "" {
+		return nil
+	}
+	ln, err := Listen("tcp", c.Addr)
+	if err != nil {
+		return err
+	}
+	server = &http.Server{Handler: Handler(c, conf)}
+	go func() {
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			tlog.Error(err)
+		}
+	}()
+	tlog.Infof("Admin: Listen=%s", ln.Addr().String())
+	return nil
+}
+
+//Close 关闭管理端口, 等待进行中的请求最多 timeout
+func Close(timeout time.Duration) {
+	if server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		tlog.Error(err)
+	}
+}
+
+//Handler 管理端口的所有路由
+func Handler(c Config, conf interface{}) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", health)
+	mux.HandleFunc("/ready", readiness)
+	mux.HandleFunc("/buildinfo", buildInfo)
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, util.MaskConfig(conf))
+	})
+	mux.HandleFunc("/discovery", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, discovery.GetState())
+	})
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/loglevel", tlog.LevelHandler())
+	if c.Pprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	return mux
+}
+
+//health 进程存活即返回 200
+func health(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+//readiness SetReady(true) 且所有检查通过时返回 200, 否则 503
+func readiness(w http.ResponseWriter, r *http.Request) {
+	checkLock.Lock()
+	names := make([]string, 0, len(checks))
+	fs := make(map[string]func() error, len(checks))
+	for name, f := range checks {
+		names = append(names, name)
+		fs[name] = f
+	}
+	checkLock.Unlock()
+	sort.Strings(names)
+
+	ok := atomic.LoadInt32(&ready) == 1
+	result := map[string]string{}
+	for _, name := range names {
+		if err := fs[name](); err != nil {
+			result[name] = err.Error()
+			ok = false
+		} else {
+			result[name] = "ok"
+		}
+	}
+	code := http.StatusOK
+	if !ok {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]interface{}{
+		"ready":  ok,
+		"checks": result,
+	})
+}
+
+type module struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+}
+
+func buildInfo(w http.ResponseWriter, r *http.Request) {
+	host, _ := os.Hostname()
+	info := map[string]interface{}{
+		"version":    Version,
+		"commit":     Commit,
+		"build_time": BuildTime,
+		"go_version": runtime.Version(),
+		"hostname":   host,
+		"pid":        os.Getpid(),
+		"start_time": util.FormatFullTime(startTime),
+		"uptime":     time.Since(startTime).Truncate(time.Second).String(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info["main"] = module{Path: bi.Main.Path, Version: bi.Main.Version}
+		deps := make([]module, 0, len(bi.Deps))
+		for _, d := range bi.Deps {
+			deps = append(deps, module{Path: d.Path, Version: d.Version})
+		}
+		info["deps"] = deps
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	bb, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(bb)
+	w.Write([]byte("\n"))
+}
@@ -0,0 +1,121 @@
+package admin
+
+import (
+	"common/util"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testConfig struct {
+	Host  string            `toml:"server_host"`
+	Db    util.MysqlConfig  `toml:"Db"`
+	Token string            `toml:"api_token"`
+	Key   string            `toml:"sign_key" mask:"true"`
+	Hook  string            `toml:"hook"`
+	Dsn   string            `toml:"dsn"`
+	Extra map[string]string `toml:"extra"`
+	Skip  string            `toml:"-"`
+}
+
+func get(t *testing.T, h http.Handler, path string) (int, []byte) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w.Code, w.Body.Bytes()
+}
+
+func TestConfig(t *testing.T) {
+	conf := &testConfig{
+		Host:  "0.0.0.0:8801",
+		Db:    util.MysqlConfig{Addr: "127.0.0.1:3306", User: "root", Pwd: "123456"},
+		Token: "abc",
+		Key:   "k",
+		Hook:  "https://oapi.dingtalk.com/robot/send?access_token=xyz&foo=bar",
+		Dsn:   "root:123456@tcp(127.0.0.1:3306)/test",
+		Extra: map[string]string{"password": "p", "name": "n"},
+		Skip:  "skip",
+	}
+	code, body := get(t, Handler(Config{}, conf), "/config")
+	if code != http.StatusOK {
+		t.Fatalf("code %d", code)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(body, &m); err != nil {
+		t.Fatal(err)
+	}
+	db := m["Db"].(map[string]interface{})
+	extra := m["extra"].(map[string]interface{})
+	cases := map[string]interface{}{
+		"server_host": "0.0.0.0:8801",
+		"db.user":     "root",
+		"db.pwd":      util.MaskedValue,
+		"api_token":   util.MaskedValue,
+		"sign_key":    util.MaskedValue,
+		"hook":        "https://oapi.dingtalk.com/robot/send?access_token=%2A%2A%2A%2A%2A%2A&foo=bar",
+		"dsn":         "root:" + util.MaskedValue + "@tcp(127.0.0.1:3306)/test",
+		"extra.pwd":   util.MaskedValue,
+		"extra.name":  "n",
+	}
+	got := map[string]interface{}{
+		"server_host": m["server_host"],
+		"db.user":     db["user"],
+		"db.pwd":      db["pwd"],
+		"api_token":   m["api_token"],
+		"sign_key":    m["sign_key"],
+		"hook":        m["hook"],
+		"dsn":         m["dsn"],
+		"extra.pwd":   extra["password"],
+		"extra.name":  extra["name"],
+	}
+	for k, v := range cases {
+		if got[k] != v {
+			t.Errorf("%s: got %v, want %v", k, got[k], v)
+		}
+	}
+	if _, ok := m["Skip"]; ok {
+		t.Error("toml:\"-\" field should be skipped")
+	}
+}
+
+func TestReady(t *testing.T) {
+	h := Handler(Config{Pprof: true}, nil)
+	if code, _ := get(t, h, "/health"); code != http.StatusOK {
+		t.Fatalf("health %d", code)
+	}
+	if code, _ := get(t, h, "/ready"); code != http.StatusServiceUnavailable {
+		t.Fatalf("ready before SetReady %d", code)
+	}
+	SetReady(true)
+	defer SetReady(false)
+	if code, _ := get(t, h, "/ready"); code != http.StatusOK {
+		t.Fatalf("ready %d", code)
+	}
+
+	var failed error = errors.New("down")
+	AddCheck("mysql", func() error { return failed })
+	defer AddCheck("mysql", func() error { return nil })
+	code, body := get(t, h, "/ready")
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("ready with failed check %d", code)
+	}
+	var r struct {
+		Ready  bool              `json:"ready"`
+		Checks map[string]string `json:"checks"`
+	}
+	json.Unmarshal(body, &r)
+	if r.Ready || r.Checks["mysql"] != "down" {
+		t.Fatalf("unexpected body %s", body)
+	}
+	failed = nil
+	if code, _ := get(t, h, "/ready"); code != http.StatusOK {
+		t.Fatalf("ready after recovery %d", code)
+	}
+
+	for _, path := range []string{"/buildinfo", "/discovery", "/metrics", "/debug/pprof/"} {
+		if code, _ := get(t, h, path); code != http.StatusOK {
+			t.Errorf("%s: %d", path, code)
+		}
+	}
+}
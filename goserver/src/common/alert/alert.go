@@ -0,0 +1,259 @@
+package alert
+
+import (
+	"common/tlog"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Config 错误日志告警配置, 未配置 Notifiers 时不启用
+type Config struct {
+	Level     string           `toml:"level"`      //ERROR 或 FATAL, 默认 ERROR
+	Window    int              `toml:"window"`     //聚合周期(秒), 默认 60
+	MaxSites  int              `toml:"max_sites"`  //一条摘要最多列出的调用位置数, 默认 20
+	RateLimit int              `toml:"rate_limit"` //每小时最多推送的摘要数, 默认 30
+	Notifiers []NotifierConfig `toml:"Notifiers"`
+}
+
+func (this *Config) check() {
+	if this.Level == "" {
+		this.Level = "ERROR"
+	}
+	if this.Window <= 0 {
+		this.Window = 60
+	}
+	if this.MaxSites <= 0 {
+		this.MaxSites = 20
+	}
+	if this.RateLimit <= 0 {
+		this.RateLimit = 30
+	}
+}
+
+//Notifier 返回第一个指定类型的推送渠道配置, 没有返回 nil
+func (this *Config) Notifier(typ string) *NotifierConfig {
+	for i := range this.Notifiers {
+		if strings.EqualFold(this.Notifiers[i].Type, typ) {
+			return &this.Notifiers[i]
+		}
+	}
+	return nil
+}
+
+const (
+	_MaxMsgLen    = 256
+	_CloseTimeout = 3 * time.Second
+)
+
+//Init 在 tlog.Init 之后调用, 把 ERROR/FATAL 日志按周期聚合后推送到机器人
+func Init(env string, app string, c Config) error {
+	if len(c.Notifiers) == 0 {
+		return nil
+	}
+	c.check()
+	level, err := tlog.ParseLevel(c.Level)
+	if err != nil {
+		return err
+	}
+	if level < tlog.ERROR {
+		level = tlog.ERROR
+	}
+
+	var notifiers []Notifier
+	for _, nc := range c.Notifiers {
+		n, err := NewNotifier(nc)
+		if err != nil {
+			return fmt.Errorf("alert: %s: %s", nc.Type, err.Error())
+		}
+		notifiers = append(notifiers, n)
+	}
+	host, _ := os.Hostname()
+	s := newSink("["+env+"] "+app+"@"+host, c, notifiers)
+	tlog.AddSink("alert", s, level, tlog.FormatText)
+	return nil
+}
+
+//site 一个调用位置在当前周期内的汇总
+type site struct {
+	key   string
+	level tlog.LEVEL
+	count int
+	msg   string
+	first time.Time
+	last  time.Time
+}
+
+type digest struct {
+	text  string
+	lines int
+}
+
+//sink 实现 tlog.RecordSink, 在 writeLoop 中调用, 只做内存聚合, 推送在单独的 goroutine
+type sink struct {
+	title     string
+	window    time.Duration
+	maxSites  int
+	rateLimit int
+	notifiers []Notifier
+
+	sites map[string]*site
+	start time.Time
+	ch    chan *digest
+	done  chan struct{}
+	once  sync.Once
+
+	sent       []time.Time
+	suppressed int
+	lost       int64 //未推送的日志条数, Flush 与 sendLoop 都会修改
+}
+
+func newSink(title string, c Config, notifiers []Notifier) *sink {
+	s := &sink{
+		title:     title,
+		window:    time.Duration(c.Window) * time.Second,
+		maxSites:  c.MaxSites,
+		rateLimit: c.RateLimit,
+		notifiers: notifiers,
+		sites:     make(map[string]*site),
+		ch:        make(chan *digest, 4),
+		done:      make(chan struct{}),
+	}
+	go s.sendLoop()
+	return s
+}
+
+func (this *sink) Write(level tlog.LEVEL, b []byte) error {
+	return nil
+}
+
+func (this *sink) WriteRecord(r *tlog.Record) error {
+	key := r.File + ":" + strconv.Itoa(r.Line)
+	st, ok := this.sites[key]
+	if !ok {
+		if len(this.sites) == 0 {
+			this.start = r.Time
+		}
+		msg := r.Msg
+		if len(msg) > _MaxMsgLen {
+			msg = msg[:_MaxMsgLen] + "..."
+		}
+		st = &site{key: key, level: r.Level, msg: msg, first: r.Time}
+		this.sites[key] = st
+	}
+	st.count++
+	st.last = r.Time
+	if r.Level > st.level {
+		st.level = r.Level
+	}
+	return nil
+}
+
+//Flush 每秒由 writeLoop 调用, 周期到了就生成摘要, 推送繁忙时丢弃并计数
+func (this *sink) Flush() error {
+	if len(this.sites) == 0 || time.Since(this.start) < this.window {
+		return nil
+	}
+	d := this.digest()
+	select {
+	case this.ch <- d:
+	default:
+		atomic.AddInt64(&this.lost, int64(d.lines))
+	}
+	return nil
+}
+
+//Close 推送剩余的摘要, 进程退出前的 FATAL 也能发出
+func (this *sink) Close() error {
+	this.once.Do(func() {
+		//入队与等待推送共用 _CloseTimeout, 推送繁忙时不会堵塞退出
+		timeout := time.NewTimer(_CloseTimeout)
+		defer timeout.Stop()
+		if len(this.sites) > 0 {
+			d := this.digest()
+			select {
+			case this.ch <- d:
+			case <-timeout.C:
+				atomic.AddInt64(&this.lost, int64(d.lines))
+				close(this.ch)
+				return
+			}
+		}
+		close(this.ch)
+		select {
+		case <-this.done:
+		case <-timeout.C:
+		}
+	})
+	return nil
+}
+
+func (this *sink) digest() *digest {
+	sites := make([]*site, 0, len(this.sites))
+	lines := 0
+	for _, st := range this.sites {
+		sites = append(sites, st)
+		lines += st.count
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		if sites[i].level != sites[j].level {
+			return sites[i].level > sites[j].level
+		}
+		return sites[i].count > sites[j].count
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d error lines from %d call sites since %s\n",
+		lines, len(sites), this.start.Format("2006-01-02 15:04:05"))
+	for i, st := range sites {
+		if i >= this.maxSites {
+			fmt.Fprintf(&b, "... and %d more call sites\n", len(sites)-i)
+			break
+		}
+		fmt.Fprintf(&b, "%s %s x%d: %s\n", st.level, st.key, st.count, st.msg)
+	}
+	this.sites = make(map[string]*site)
+	return &digest{text: b.String(), lines: lines}
+}
+
+func (this *sink) sendLoop() {
+	defer close(this.done)
+	for d := range this.ch {
+		if !this.allow() {
+			this.suppressed++
+			atomic.AddInt64(&this.lost, int64(d.lines))
+			continue
+		}
+		text := d.text
+		if lost := atomic.SwapInt64(&this.lost, 0); lost > 0 {
+			text += fmt.Sprintf("(%d digests with %d lines suppressed by rate limit)\n", this.suppressed, lost)
+			this.suppressed = 0
+		}
+		for _, n := range this.notifiers {
+			//不能写 tlog, 否则推送失败会产生新的告警
+			if err := n.Notify(this.title, text); err != nil {
+				fmt.Fprintf(os.Stderr, "alert: notify error: %s\n", err.Error())
+			}
+		}
+	}
+}
+
+//allow 最近一小时内推送次数未超过 rateLimit
+func (this *sink) allow() bool {
+	now := time.Now()
+	i := 0
+	for i < len(this.sent) && now.Sub(this.sent[i]) >= time.Hour {
+		i++
+	}
+	this.sent = this.sent[i:]
+	if len(this.sent) >= this.rateLimit {
+		return false
+	}
+	this.sent = append(this.sent, now)
+	return true
+}
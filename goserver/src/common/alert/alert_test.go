@@ -0,0 +1,94 @@
+package alert
+
+import (
+	"common/tlog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testNotifier struct {
+	sync.Mutex
+	texts []string
+}
+
+func (this *testNotifier) Notify(title string, text string) error {
+	this.Lock()
+	this.texts = append(this.texts, text)
+	this.Unlock()
+	return nil
+}
+
+func TestDigest(t *testing.T) {
+	n := &testNotifier{}
+	c := Config{RateLimit: 1}
+	c.check()
+	s := newSink("[test] alert", c, []Notifier{n})
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		s.WriteRecord(&tlog.Record{Time: now, Level: tlog.ERROR, File: "a/a.go", Line: 10, Msg: "db error"})
+	}
+	s.WriteRecord(&tlog.Record{Time: now, Level: tlog.FATAL, File: "b/b.go", Line: 20, Msg: "panic"})
+	if len(s.sites) != 2 {
+		t.Fatalf("sites = %d, want 2", len(s.sites))
+	}
+	//周期未到不推送
+	s.Flush()
+	if len(s.sites) != 2 {
+		t.Fatal("flushed before window")
+	}
+
+	s.start = now.Add(-s.window)
+	s.Flush()
+	//超过每小时限流的摘要不推送
+	s.WriteRecord(&tlog.Record{Time: now, Level: tlog.ERROR, File: "a/a.go", Line: 10, Msg: "db error"})
+	s.Close()
+
+	if len(n.texts) != 1 {
+		t.Fatalf("notify %d times, want 1", len(n.texts))
+	}
+	text := n.texts[0]
+	if !strings.Contains(text, "4 error lines from 2 call sites") {
+		t.Fatal(text)
+	}
+	if strings.Index(text, "FATAL b/b.go:20 x1: panic") > strings.Index(text, "ERROR a/a.go:10 x3: db error") {
+		t.Fatal("FATAL should be listed first:", text)
+	}
+}
+
+type blockNotifier struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (this *blockNotifier) Notify(title string, text string) error {
+	this.entered <- struct{}{}
+	<-this.release
+	return nil
+}
+
+func TestCloseTimeout(t *testing.T) {
+	n := &blockNotifier{entered: make(chan struct{}, 10), release: make(chan struct{})}
+	defer close(n.release)
+	c := Config{}
+	c.check()
+	s := newSink("[test] alert", c, []Notifier{n})
+
+	//推送堵塞, 队列积满后 Close 仍在 _CloseTimeout 内返回
+	s.ch <- &digest{}
+	<-n.entered
+	for len(s.ch) < cap(s.ch) {
+		s.ch <- &digest{}
+	}
+	s.WriteRecord(&tlog.Record{Time: time.Now(), Level: tlog.ERROR, File: "a/a.go", Line: 10, Msg: "db error"})
+	start := time.Now()
+	s.Close()
+	if d := time.Since(start); d > _CloseTimeout+time.Second {
+		t.Fatalf("Close took %s", d)
+	}
+	if s.lost != 1 {
+		t.Fatalf("lost = %d, want 1", s.lost)
+	}
+}
@@ -0,0 +1,191 @@
+package alert
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TypeDingDing = "dingding"
+	TypeFeishu   = "feishu"
+	TypeLark     = "lark"
+	TypeSlack    = "slack"
+	TypeWebhook  = "webhook"
+)
+
+var ErrUnknownType = errors.New("unknown notifier type")
+
+//Notifier 告警推送渠道
+type Notifier interface {
+	Notify(title string, text string) error
+}
+
+//NotifierConfig 推送渠道配置
+type NotifierConfig struct {
+	Type   string `toml:"type"`   //dingding/feishu/lark/slack/webhook
+	Url    string `toml:"url"`    //机器人 webhook 地址
+	Secret string `toml:"secret"` //加签密钥, dingding/feishu 可选
+}
+
+//NewNotifier 按配置创建推送渠道
+func NewNotifier(c NotifierConfig) (Notifier, error) {
+	if c.Url == "" {
+		return nil, errors.New(c.Type + " notifier url is empty")
+	}
+	switch strings.ToLower(c.Type) {
+	case TypeDingDing:
+		return NewDingDing(c.Url, c.Secret), nil
+	case TypeFeishu, TypeLark:
+		return &Feishu{url: c.Url, secret: c.Secret, client: newHttpClient()}, nil
+	case TypeSlack:
+		return &Slack{url: c.Url, client: newHttpClient()}, nil
+	case TypeWebhook:
+		return &Webhook{url: c.Url, client: newHttpClient()}, nil
+	}
+	return nil, ErrUnknownType
+}
+
+//newHttpClient 推送失败不能写 tlog, 否则错误日志会再次触发告警, 所以不用 util.HttpPost
+func newHttpClient() *http.Client {
+	return &http.Client{Timeout: 5 * time.Second}
+}
+
+func post(client *http.Client, url string, data interface{}) ([]byte, error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Post(url, "application/json;charset=UTF-8", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	ret, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return ret, fmt.Errorf("http status %d: %s", resp.StatusCode, ret)
+	}
+	return ret, nil
+}
+
+func hmacBase64(key string, msg string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(msg))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+//DingDing 钉钉群机器人
+type DingDing struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func NewDingDing(url string, secret string) *DingDing {
+	return &DingDing{url: url, secret: secret, client: newHttpClient()}
+}
+
+func (this *DingDing) Notify(title string, text string) error {
+	ret, err := this.Send(title + "\n" + text)
+	if err != nil {
+		return err
+	}
+	var r struct {
+		Errcode int    `json:"errcode"`
+		Errmsg  string `json:"errmsg"`
+	}
+	if json.Unmarshal([]byte(ret), &r) == nil && r.Errcode != 0 {
+		return fmt.Errorf("dingding errcode %d: %s", r.Errcode, r.Errmsg)
+	}
+	return nil
+}
+
+//Send 发送文本消息, 返回钉钉的原始响应
+func (this *DingDing) Send(content string) (string, error) {
+	u := this.url
+	if this.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix()*1000, 10)
+		sign := hmacBase64(this.secret, timestamp+"\n"+this.secret)
+		u += "&timestamp=" + timestamp + "&sign=" + url.QueryEscape(sign)
+	}
+
+	type msgText struct {
+		Content string `json:"content"`
+	}
+	type msgData struct {
+		Msgtype string  `json:"msgtype"`
+		Text    msgText `json:"text"`
+	}
+	ret, err := post(this.client, u, msgData{Msgtype: "text", Text: msgText{Content: content}})
+	return string(ret), err
+}
+
+//Feishu 飞书/Lark 群机器人
+type Feishu struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func (this *Feishu) Notify(title string, text string) error {
+	data := map[string]interface{}{
+		"msg_type": "text",
+		"content":  map[string]string{"text": title + "\n" + text},
+	}
+	if this.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		data["timestamp"] = timestamp
+		data["sign"] = hmacBase64(timestamp+"\n"+this.secret, "")
+	}
+	ret, err := post(this.client, this.url, data)
+	if err != nil {
+		return err
+	}
+	var r struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	if json.Unmarshal(ret, &r) == nil && r.Code != 0 {
+		return fmt.Errorf("feishu code %d: %s", r.Code, r.Msg)
+	}
+	return nil
+}
+
+//Slack incoming webhook
+type Slack struct {
+	url    string
+	client *http.Client
+}
+
+func (this *Slack) Notify(title string, text string) error {
+	_, err := post(this.client, this.url, map[string]string{"text": "*" + title + "*\n```" + text + "```"})
+	return err
+}
+
+//Webhook 通用 webhook, POST JSON {"title": "", "text": "", "time": ""}
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+func (this *Webhook) Notify(title string, text string) error {
+	_, err := post(this.client, this.url, map[string]string{
+		"title": title,
+		"text":  text,
+		"time":  time.Now().Format("2006-01-02 15:04:05"),
+	})
+	return err
+}
@@ -0,0 +1,342 @@
+package app
+
+//服务启动框架: 公共初始化, 组件按注册顺序启动, 收到退出信号后逆序关闭
+//收到 SIGUSR2 时平滑重启: 把 HTTPServer 与管理端口的监听交给新进程, 新进程就绪后向本进程发送 SIGTERM
+
+import (
+	"common/admin"
+	"common/alert"
+	"common/discovery"
+	"common/metrics"
+	"common/tlog"
+	"common/util"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/facebookgo/grace/gracenet"
+)
+
+const (
+	_StartTimeout = 30 * time.Second
+	_StopTimeout  = 15 * time.Second
+)
+
+//Config 各服务共用的配置, 匿名嵌入到服务的配置中, toml 字段保持在顶层
+type Config struct {
+	Env     string         `toml:"env" validate:"required"`
+	EtcdEnv string         `toml:"etcd_env"` //为空时与 env 相同
+	Etcd    []string       `toml:"etcd"`     //为空时不初始化服务发现
+	Log     tlog.Config    `toml:"Log"`
+	Alert   alert.Config   `toml:"Alert"`
+	Metrics metrics.Config `toml:"Metrics"`
+	Admin   admin.Config   `toml:"Admin"`
+
+	StartTimeout  int `toml:"start_timeout" validate:"gte=0"`  //每个组件 init/start/ready 的超时(秒), 默认 30
+	StopTimeout   int `toml:"stop_timeout" validate:"gte=0"`   //每个组件 stop 的超时(秒), 默认 15
+	ShutdownDelay int `toml:"shutdown_delay" validate:"gte=0"` //摘除流量后等待的时间(秒), 留给负载均衡与调用方刷新节点
+
+	SnapshotDir string `toml:"snapshot_dir"` //服务发现的节点快照目录, 为空时为可执行文件所在目录下的 discovery_snapshot
+}
+
+//ErrTimeout 组件的钩子在超时时间内没有返回
+var ErrTimeout = errors.New("timeout")
+
+//errInterrupted 启动过程中收到退出信号或 Shutdown
+var errInterrupted = errors.New("interrupted")
+
+//graceNet 平滑重启时继承与传递监听
+var graceNet = &gracenet.Net{}
+
+//App 一个服务进程
+type App struct {
+	name string
+	c    *Config
+	conf interface{}
+
+	components []*Component
+	inited     int //已完成 init 的组件数, 关闭时只处理这些组件
+	started    int //已完成 start 的组件数
+
+	failOnce sync.Once
+	failed   chan error
+	quit     chan struct{}
+	quitOnce sync.Once
+	sigs     chan os.Signal
+}
+
+//New name 为服务名, 用于指标与告警; conf 为服务的完整配置, 由管理端口的 /config 输出
+func New(name string, c *Config, conf interface{}) *App {
+	if c.EtcdEnv == "" {
+		c.EtcdEnv = c.Env
+	}
+	return &App{
+		name:   name,
+		c:      c,
+		conf:   conf,
+		failed: make(chan error, 1),
+		quit:   make(chan struct{}),
+		sigs:   make(chan os.Signal, 2),
+	}
+}
+
+func (this *App) Name() string {
+	return this.name
+}
+
+//Add 注册组件, 按注册顺序启动, 逆序关闭, 须在 Run 之前调用
+func (this *App) Add(cs ...*Component) *App {
+	this.components = append(this.components, cs...)
+	return this
+}
+
+//Fail 组件运行中出现不可恢复的错误, 例如监听的端口被关闭, 进程随之退出
+func (this *App) Fail(err error) {
+	this.failOnce.Do(func() {
+		this.failed <- err
+	})
+}
+
+//Shutdown 主动退出, 与收到 SIGTERM 相同
+func (this *App) Shutdown() {
+	this.quitOnce.Do(func() {
+		close(this.quit)
+	})
+}
+
+//Run 初始化公共模块, 启动所有组件, 堵塞等待退出信号后按逆序关闭
+func (this *App) Run() error {
+	//启动之前就监听信号, 启动过程中收到 SIGTERM 也会关闭已启动的组件
+	signal.Notify(this.sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR2)
+	if err := this.bootstrap(); err != nil {
+		tlog.Close()
+		return err
+	}
+
+	err := this.start()
+	if err == nil {
+		admin.SetReady(true)
+		fmt.Println(util.FormatFullTime(time.Now()), this.name, "running ...")
+		this.killParent()
+		err = this.wait()
+	} else if err == errInterrupted {
+		err = nil
+	}
+	go this.forceExit()
+	admin.SetReady(false)
+	this.stop()
+
+	if err != nil {
+		tlog.Errorf("App: Exit Error=%s", err.Error())
+	}
+	tlog.Info("App: Exit")
+	metrics.Flush()
+	admin.Close(time.Second)
+	if len(this.c.Etcd) > 0 {
+		discovery.Close()
+	}
+	tlog.Close()
+	return err
+}
+
+//bootstrap 日志, 服务发现, 指标, 告警与管理端口
+func (this *App) bootstrap() error {
+	tlog.Init(this.c.Log)
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	rand.Seed(time.Now().UnixNano())
+
+	if len(this.c.Etcd) > 0 {
+		discovery.SetSnapshotDir(this.snapshotDir())
+		discovery.Init(this.c.Etcd...)
+	}
+	metrics.InitWithConfig(this.name, this.c.Env, this.c.Metrics)
+	if err := alert.Init(this.c.Env, this.name, this.c.Alert); err != nil {
+		tlog.Error(err)
+	}
+	admin.Listen = graceNet.Listen
+	return admin.Start(this.c.Admin, this.conf)
+}
+
+//snapshotDir 未配置时使用可执行文件所在的目录, 不受启动时工作目录的影响
+func (this *App) snapshotDir() string {
+	if this.c.SnapshotDir != "" {
+		return this.c.SnapshotDir
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return "./discovery_snapshot"
+	}
+	return filepath.Join(filepath.Dir(exe), "discovery_snapshot")
+}
+
+//start 所有组件依次 init, 再依次 start, 最后依次 ready, 每个钩子之前检查是否已收到退出信号
+func (this *App) start() error {
+	timeout := this.timeout(this.c.StartTimeout, _StartTimeout)
+	for _, c := range this.components {
+		if err := this.interrupted(); err != nil {
+			return err
+		}
+		if err := this.call(c, "init", c.Init, timeout); err != nil {
+			return err
+		}
+		this.inited++
+	}
+	for _, c := range this.components {
+		if err := this.interrupted(); err != nil {
+			return err
+		}
+		if err := this.call(c, "start", c.Start, timeout); err != nil {
+			return err
+		}
+		this.started++
+	}
+	for _, c := range this.components {
+		if err := this.interrupted(); err != nil {
+			return err
+		}
+		if err := this.call(c, "ready", c.Ready, timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//interrupted 启动过程中收到 SIGINT/SIGTERM 或 Shutdown 时返回 errInterrupted, 组件失败时返回其错误,
+//启动完成前不处理 SIGUSR2
+func (this *App) interrupted() error {
+	for {
+		select {
+		case sig := <-this.sigs:
+			if sig == syscall.SIGUSR2 {
+				tlog.Infof("App: Signal=%s Ignored During Start", sig.String())
+				continue
+			}
+			tlog.Infof("App: Signal=%s During Start", sig.String())
+			return errInterrupted
+		case err := <-this.failed:
+			return err
+		case <-this.quit:
+			return errInterrupted
+		default:
+			return nil
+		}
+	}
+}
+
+//wait 等待退出信号, 组件失败或 Shutdown, 收到 SIGUSR2 时启动新进程并继续等待
+func (this *App) wait() error {
+	for {
+		select {
+		case sig := <-this.sigs:
+			if sig == syscall.SIGUSR2 {
+				this.restart()
+				continue
+			}
+			tlog.Infof("App: Signal=%s", sig.String())
+			return nil
+		case err := <-this.failed:
+			return err
+		case <-this.quit:
+			return nil
+		}
+	}
+}
+
+//forceExit 关闭过程中再次收到 SIGINT/SIGTERM 时立即退出
+func (this *App) forceExit() {
+	for sig := range this.sigs {
+		if sig == syscall.SIGUSR2 {
+			continue
+		}
+		tlog.Errorf("App: Signal=%s Again, Exit Immediately", sig.String())
+		tlog.Close()
+		os.Exit(1)
+	}
+}
+
+//restart 启动新进程并传递监听, 本进程在新进程就绪后收到 SIGTERM 再退出
+func (this *App) restart() {
+	pid, err := graceNet.StartProcess()
+	if err != nil {
+		tlog.Errorf("App: Restart Error=%s", err.Error())
+		return
+	}
+	tlog.Infof("App: Restart NewPid=%d", pid)
+}
+
+//killParent 由平滑重启启动的进程在就绪后通知旧进程退出
+func (this *App) killParent() {
+	ppid := os.Getppid()
+	if os.Getenv("LISTEN_FDS") == "" || ppid == 1 {
+		return
+	}
+	tlog.Infof("App: Inherited Listeners, Terminate Parent Pid=%d", ppid)
+	if err := syscall.Kill(ppid, syscall.SIGTERM); err != nil {
+		tlog.Errorf("App: Terminate Parent Error=%s", err.Error())
+	}
+}
+
+//stop 先从服务发现注销, 等待 shutdown_delay 后逆序关闭组件, etcd 连接在 Run 的最后关闭
+//只关闭完成了 init 的组件, 未完成 start 的组件同样会调用 stop 以释放 init 中的资源
+func (this *App) stop() {
+	if len(this.c.Etcd) > 0 {
+		discovery.Deregister()
+	}
+	if this.started > 0 && this.c.ShutdownDelay > 0 {
+		tlog.Infof("App: Shutdown Delay=%ds", this.c.ShutdownDelay)
+		time.Sleep(time.Duration(this.c.ShutdownDelay) * time.Second)
+	}
+	for i := this.inited - 1; i >= 0; i-- {
+		c := this.components[i]
+		this.call(c, "stop", c.Stop, this.timeout(this.c.StopTimeout, _StopTimeout))
+	}
+	this.inited, this.started = 0, 0
+}
+
+func (this *App) timeout(seconds int, def time.Duration) time.Duration {
+	if seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return def
+}
+
+//call 执行钩子, 超时后不再等待, 钩子可通过 ctx 感知超时
+func (this *App) call(c *Component, phase string, hook Hook, timeout time.Duration) error {
+	if hook == nil {
+		return nil
+	}
+	begin := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- hook(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ErrTimeout
+	}
+	if err != nil {
+		tlog.Errorf("App: Component=%s Phase=%s Error=%s", c.Name, phase, err.Error())
+		return fmt.Errorf("%s %s: %s", c.Name, phase, err.Error())
+	}
+	tlog.Infof("App: Component=%s Phase=%s Cost=%s", c.Name, phase, time.Since(begin).String())
+	return nil
+}
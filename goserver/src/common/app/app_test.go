@@ -0,0 +1,134 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func recorder(log *[]string, name string, fail string) *Component {
+	hook := func(phase string) Hook {
+		return func(ctx context.Context) error {
+			*log = append(*log, name+"."+phase)
+			if phase == fail {
+				return errors.New("failed")
+			}
+			return nil
+		}
+	}
+	return &Component{
+		Name:  name,
+		Init:  hook("init"),
+		Start: hook("start"),
+		Ready: hook("ready"),
+		Stop:  hook("stop"),
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	var log []string
+	a := New("test", &Config{}, nil)
+	a.Add(recorder(&log, "db", ""), recorder(&log, "http", ""))
+	if err := a.start(); err != nil {
+		t.Fatal(err)
+	}
+	a.stop()
+	want := []string{
+		"db.init", "http.init",
+		"db.start", "http.start",
+		"db.ready", "http.ready",
+		"http.stop", "db.stop",
+	}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("got %v, want %v", log, want)
+	}
+
+	//init 失败时只关闭已经 init 的组件
+	log = nil
+	a = New("test", &Config{}, nil)
+	a.Add(recorder(&log, "db", ""), recorder(&log, "redis", "init"), recorder(&log, "http", ""))
+	err := a.start()
+	if err == nil || err.Error() != "redis init: failed" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	a.stop()
+	want = []string{"db.init", "redis.init", "db.stop"}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("got %v, want %v", log, want)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	a := New("test", &Config{StartTimeout: 1}, nil)
+	a.Add(&Component{
+		Name: "slow",
+		Init: func(ctx context.Context) error {
+			time.Sleep(3 * time.Second)
+			return nil
+		},
+	})
+	begin := time.Now()
+	err := a.start()
+	if err == nil || !strings.Contains(err.Error(), ErrTimeout.Error()) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if time.Since(begin) > 2*time.Second {
+		t.Fatalf("start did not time out: %s", time.Since(begin))
+	}
+
+	a = New("test", &Config{}, nil)
+	a.Add(&Component{Name: "panic", Init: func(ctx context.Context) error { panic("boom") }})
+	if err := a.start(); err == nil || err.Error() != "panic init: panic: boom" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestFail(t *testing.T) {
+	a := New("test", &Config{}, nil)
+	a.Fail(errors.New("listener closed"))
+	a.Fail(errors.New("ignored"))
+	if err := a.wait(); err == nil || err.Error() != "listener closed" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	a = New("test", &Config{}, nil)
+	a.Shutdown()
+	a.Shutdown()
+	if err := a.wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	//启动过程中收到 SIGTERM, 之后的组件不再启动, 已 init 的组件被关闭
+	var log []string
+	a := New("test", &Config{}, nil)
+	db := recorder(&log, "db", "")
+	dbInit := db.Init
+	db.Init = func(ctx context.Context) error {
+		a.sigs <- syscall.SIGUSR2
+		a.sigs <- syscall.SIGTERM
+		return dbInit(ctx)
+	}
+	a.Add(db, recorder(&log, "http", ""))
+	if err := a.start(); err != errInterrupted {
+		t.Fatalf("unexpected error %v", err)
+	}
+	a.stop()
+	want := []string{"db.init", "db.stop"}
+	if !reflect.DeepEqual(log, want) {
+		t.Fatalf("got %v, want %v", log, want)
+	}
+
+	//启动过程中组件失败
+	log = nil
+	a = New("test", &Config{}, nil)
+	a.Add(recorder(&log, "db", ""), recorder(&log, "http", ""))
+	a.Fail(errors.New("listener closed"))
+	if err := a.start(); err == nil || err.Error() != "listener closed" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package app
+
+import (
+	"common/admin"
+	"common/confcenter"
+	"common/discovery"
+	"common/feature"
+	"common/metrics"
+	"common/util"
+	"context"
+	"database/sql"
+	"net/http"
+
+	"github.com/go-redis/redis"
+	"google.golang.org/grpc"
+)
+
+//Hook 生命周期钩子, ctx 在超时后取消
+type Hook func(ctx context.Context) error
+
+//Component 一个可启动与关闭的模块, 钩子均可为空
+//Init 创建连接等资源, Start 开始对外服务, Ready 所有组件启动后执行(如注册服务发现), Stop 释放资源
+type Component struct {
+	Name  string
+	Init  Hook
+	Start Hook
+	Ready Hook
+	Stop  Hook
+}
+
+//ConfCenter 读取 etcd 中 /config/{etcd_env}/{服务名}/ 下的动态配置, 由其中的 log 项控制日志级别,
+//需要配置 etcd, 业务通过 confcenter.Default().Bind 监听配置变化
+func (this *App) ConfCenter() *Component {
+	return &Component{
+		Name: "confcenter",
+		Init: func(ctx context.Context) error {
+			if err := confcenter.Init(this.c.EtcdEnv, this.name); err != nil {
+				return err
+			}
+			return confcenter.Default().BindLogLevel()
+		},
+		Stop: func(ctx context.Context) error {
+			confcenter.Close()
+			return nil
+		},
+	}
+}
+
+//Features 从配置中心加载功能开关, 须在 ConfCenter 之后, 没有配置中心时快照为空, 开关取值均为空字符串
+func Features() *Component {
+	return &Component{
+		Name: "feature",
+		Init: func(ctx context.Context) error {
+			if confcenter.Default() == nil {
+				return feature.Load(&feature.Config{})
+			}
+			return feature.Init(confcenter.Default())
+		},
+	}
+}
+
+//Mysql 在 init 中连接数据库并赋值给 db, 注册连接池指标与就绪检查
+func Mysql(name string, c *util.MysqlConfig, db **sql.DB) *Component {
+	return &Component{
+		Name: "mysql." + name,
+		Init: func(ctx context.Context) error {
+			d, err := util.NewMysql(c)
+			if err != nil {
+				return err
+			}
+			if err = d.PingContext(ctx); err != nil {
+				d.Close()
+				return err
+			}
+			//超时后 App 不再等待, 晚到的连接直接关闭, 避免无人释放
+			if err = ctx.Err(); err != nil {
+				d.Close()
+				return err
+			}
+			*db = d
+			metrics.RegisterCollector("mysql."+name, metrics.DBCollector(name, d))
+			admin.AddCheck("mysql."+name, d.Ping)
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			return (*db).Close()
+		},
+	}
+}
+
+//Redis 在 init 中连接 redis 并赋值给 client, 注册连接池指标与就绪检查
+func Redis(name string, c *util.RedisConfig, client **redis.Client) *Component {
+	return &Component{
+		Name: "redis." + name,
+		Init: func(ctx context.Context) error {
+			cl, err := util.NewRedisClient(c)
+			if err != nil {
+				return err
+			}
+			//超时后 App 不再等待, 晚到的连接直接关闭, 避免无人释放
+			if err = ctx.Err(); err != nil {
+				cl.Close()
+				return err
+			}
+			*client = cl
+			metrics.RegisterCollector("redis."+name, metrics.RedisCollector(name, cl))
+			admin.AddCheck("redis."+name, func() error { return cl.Ping().Err() })
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			return (*client).Close()
+		},
+	}
+}
+
+//HTTPServer start 时监听端口, 端口被占用则启动失败, 运行中出错时进程退出, stop 时等待请求处理完成,
+//平滑重启(SIGUSR2)时新进程继承该监听, 不中断连接
+func (this *App) HTTPServer(name string, srv *http.Server) *Component {
+	return &Component{
+		Name: "http." + name,
+		Start: func(ctx context.Context) error {
+			ln, err := graceNet.Listen("tcp", srv.Addr)
+			if err != nil {
+				return err
+			}
+			go func() {
+				if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+					this.Fail(err)
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			return srv.Shutdown(ctx)
+		},
+	}
+}
+
+//GRPCServer start 时监听随机端口, 调用 register 注册服务实现,
+//ready 时以 name 注册到服务发现(未配置 etcd 时跳过), stop 时等待请求处理完成, 超时则强制关闭,
+//interceptors 在 discovery.UnaryServerInterceptor 之后执行
+func (this *App) GRPCServer(name string, register func(srv *grpc.Server), interceptors ...grpc.UnaryServerInterceptor) *Component {
+	srv := discovery.NewGrpcServer(interceptors...)
+	var addr string
+	return &Component{
+		Name: "grpc." + name,
+		Start: func(ctx context.Context) error {
+			ln, a, err := discovery.Listen()
+			if err != nil {
+				return err
+			}
+			addr = a
+			register(srv)
+			go func() {
+				if err := srv.Serve(ln); err != nil {
+					this.Fail(err)
+				}
+			}()
+			return nil
+		},
+		Ready: func(ctx context.Context) error {
+			if len(this.c.Etcd) == 0 {
+				return nil
+			}
+			discovery.Register(this.c.EtcdEnv, &discovery.Service{Name: name, Addr: addr})
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			done := make(chan struct{})
+			go func() {
+				srv.GracefulStop()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-ctx.Done():
+				srv.Stop()
+			}
+			return nil
+		},
+	}
+}
+
+//Consumer rocketmqc 的 RocketmqConsumer 与 RocketmqOrderlyConsumer 都实现了该接口
+type Consumer interface {
+	Start() error
+	Close()
+}
+
+//MQConsumer init 时调用 create 创建消费者, start 时开始消费, stop 时关闭
+func MQConsumer(name string, create func() (Consumer, error)) *Component {
+	var c Consumer
+	return &Component{
+		Name: "mq." + name,
+		Init: func(ctx context.Context) (err error) {
+			c, err = create()
+			return err
+		},
+		Start: func(ctx context.Context) error {
+			return c.Start()
+		},
+		Stop: func(ctx context.Context) error {
+			c.Close()
+			return nil
+		},
+	}
+}
@@ -0,0 +1,428 @@
+package confcenter
+
+//配置中心: 配置存放在 etcd 的 /config/{env}/{service}/{key}, 值为 toml
+//每次修改同时在同一事务中写入 /config_history/{env}/{service}/{key}/{纳秒时间}, 用于查看历史与回滚
+
+import (
+	"common/discovery"
+	"common/tlog"
+	"common/util"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	etcd "go.etcd.io/etcd/clientv3"
+)
+
+const (
+	_KeyFormat     = "/config/%s/%s/"
+	_HistoryFormat = "/config_history/%s/%s/%s/"
+
+	//每个 key 保留的历史版本数
+	_HistoryLimit = 20
+	//读写 etcd 的超时时间
+	_Timeout = 3 * time.Second
+	//watch 中断后重试的间隔
+	_RetryInterval = time.Second
+)
+
+var (
+	ErrNotFound    = errors.New("config key not found")
+	ErrNoEtcd      = errors.New("etcd is not initialized, call discovery.Init first")
+	ErrNoRevision  = errors.New("revision not found in history")
+	ErrInvalidType = errors.New("bind value must be a pointer to struct")
+)
+
+//Item 一个配置项
+type Item struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Revision int64  `json:"revision"`       //etcd 的修改版本, 全局递增, 回滚时指定
+	Version  int64  `json:"version"`        //该 key 的修改次数
+	Time     int64  `json:"time,omitempty"` //历史记录的写入时间
+}
+
+//history 历史记录的值
+type history struct {
+	Value string `json:"value"`
+	Time  int64  `json:"time"`
+}
+
+type binding struct {
+	typ      reflect.Type
+	onChange func(v interface{})
+
+	lock sync.Mutex
+	rev  int64 //已交给绑定者的 revision, 更旧的通知被忽略
+}
+
+//Center 一个服务的配置, 启动时全量读取, 之后通过 watch 增量更新
+type Center struct {
+	client  *etcd.Client
+	env     string
+	service string
+	prefix  string
+
+	lock     sync.RWMutex
+	items    map[string]*Item
+	bindings map[string][]*binding
+	rev      int64 //已处理的最大 revision
+
+	cancel context.CancelFunc
+}
+
+var center *Center
+
+//Init 使用 discovery 的 etcd 连接创建默认的配置中心
+func Init(env string, service string) error {
+	if discovery.Client() == nil {
+		return ErrNoEtcd
+	}
+	c, err := New(discovery.Client(), env, service)
+	if err != nil {
+		return err
+	}
+	center = c
+	return nil
+}
+
+//Default Init 创建的配置中心, 未 Init 时为 nil
+func Default() *Center {
+	return center
+}
+
+//Close 停止默认配置中心的 watch
+func Close() {
+	if center != nil {
+		center.Close()
+	}
+}
+
+//New 读取 /config/{env}/{service}/ 下的所有配置并开始 watch,
+//etcd 不可用时以空配置启动, 在后台重试读取, 读取到的配置同样通知绑定者
+func New(client *etcd.Client, env string, service string) (*Center, error) {
+	this := newCenter(env, service)
+	this.client = client
+	loaded := true
+	if err := this.reload(); err != nil {
+		tlog.Errorf("ConfCenter: Load Dir=%s Error=%s", this.prefix, err.Error())
+		loaded = false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	this.cancel = cancel
+	go this.watching(ctx, loaded)
+	return this, nil
+}
+
+func newCenter(env string, service string) *Center {
+	return &Center{
+		env:      env,
+		service:  service,
+		prefix:   fmt.Sprintf(_KeyFormat, env, service),
+		items:    map[string]*Item{},
+		bindings: map[string][]*binding{},
+	}
+}
+
+func (this *Center) Close() {
+	if this.cancel != nil {
+		this.cancel()
+	}
+}
+
+//Items 当前所有的配置项
+func (this *Center) Items() []*Item {
+	this.lock.RLock()
+	items := make([]*Item, 0, len(this.items))
+	for _, item := range this.items {
+		items = append(items, item)
+	}
+	this.lock.RUnlock()
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	return items
+}
+
+//Item 当前的配置项
+func (this *Center) Item(key string) (*Item, bool) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	item, ok := this.items[key]
+	return item, ok
+}
+
+//Get 将当前值解析到 v, v 为结构体指针, 不存在时返回 ErrNotFound
+func (this *Center) Get(key string, v interface{}) error {
+	item, ok := this.Item(key)
+	if !ok {
+		return ErrNotFound
+	}
+	return decode(item.Value, v)
+}
+
+//Bind 将当前值解析到 v (不存在时保持 v 不变), 之后每次变化时解析到同类型的新值并调用 onChange,
+//解析或 validate 标签校验失败的修改会被忽略并记录错误日志, onChange 在 watch 协程中依次调用, 不要堵塞;
+//Bind 返回之前不会调用 onChange, 也不会通知不比当前值新的修改
+func (this *Center) Bind(key string, v interface{}, onChange func(v interface{})) error {
+	t := reflect.TypeOf(v)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return ErrInvalidType
+	}
+	b := &binding{typ: t.Elem(), onChange: onChange}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	this.lock.Lock()
+	item := this.items[key]
+	if item != nil {
+		b.rev = item.Revision
+	}
+	this.bindings[key] = append(this.bindings[key], b)
+	this.lock.Unlock()
+
+	if item != nil {
+		return decode(item.Value, v)
+	}
+	return nil
+}
+
+//Set 写入新值并记录历史, 返回新的 revision
+func (this *Center) Set(key string, value string) (int64, error) {
+	var m map[string]interface{}
+	if _, err := toml.Decode(value, &m); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	bb, _ := json.Marshal(&history{Value: value, Time: now.Unix()})
+	historyKey := fmt.Sprintf(_HistoryFormat, this.env, this.service, key) + fmt.Sprintf("%019d", now.UnixNano())
+
+	ctx, cancel := context.WithTimeout(context.Background(), _Timeout)
+	defer cancel()
+	resp, err := this.client.Txn(ctx).Then(
+		etcd.OpPut(this.prefix+key, value),
+		etcd.OpPut(historyKey, string(bb)),
+	).Commit()
+	if err != nil {
+		return 0, err
+	}
+	tlog.Infof("ConfCenter: Set Key=%s%s Revision=%d", this.prefix, key, resp.Header.Revision)
+	this.trimHistory(key)
+	return resp.Header.Revision, nil
+}
+
+//Delete 删除配置项, 历史保留
+func (this *Center) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), _Timeout)
+	defer cancel()
+	_, err := this.client.Delete(ctx, this.prefix+key)
+	return err
+}
+
+//History 最近的修改记录, 新的在前
+func (this *Center) History(key string) ([]*Item, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), _Timeout)
+	defer cancel()
+	resp, err := this.client.Get(ctx, fmt.Sprintf(_HistoryFormat, this.env, this.service, key),
+		etcd.WithPrefix(), etcd.WithSort(etcd.SortByKey, etcd.SortDescend))
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*Item, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var h history
+		if err := json.Unmarshal(kv.Value, &h); err != nil {
+			tlog.Errorf("ConfCenter: History Key=%s Error=%s", string(kv.Key), err.Error())
+			continue
+		}
+		items = append(items, &Item{Key: key, Value: h.Value, Revision: kv.ModRevision, Time: h.Time})
+	}
+	return items, nil
+}
+
+//Rollback 将 key 恢复为 revision 时的值, 回滚本身也是一次修改, 返回新的 revision
+func (this *Center) Rollback(key string, revision int64) (int64, error) {
+	items, err := this.History(key)
+	if err != nil {
+		return 0, err
+	}
+	for _, item := range items {
+		if item.Revision == revision {
+			tlog.Infof("ConfCenter: Rollback Key=%s%s To Revision=%d", this.prefix, key, revision)
+			return this.Set(key, item.Value)
+		}
+	}
+	return 0, ErrNoRevision
+}
+
+//trimHistory 只保留最近 _HistoryLimit 条
+func (this *Center) trimHistory(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), _Timeout)
+	defer cancel()
+	dir := fmt.Sprintf(_HistoryFormat, this.env, this.service, key)
+	resp, err := this.client.Get(ctx, dir, etcd.WithPrefix(), etcd.WithKeysOnly(),
+		etcd.WithSort(etcd.SortByKey, etcd.SortDescend))
+	if err != nil || len(resp.Kvs) <= _HistoryLimit {
+		return
+	}
+	//历史 key 以定长的纳秒时间结尾, 删除 [dir, 保留的最旧一条) 之间的 key
+	keep := string(resp.Kvs[_HistoryLimit-1].Key)
+	if _, err := this.client.Delete(ctx, dir, etcd.WithRange(keep)); err != nil {
+		tlog.Errorf("ConfCenter: Trim History Dir=%s Error=%s", dir, err.Error())
+	}
+}
+
+//reload 全量读取, 用于启动与 watch 的 revision 被压缩之后
+func (this *Center) reload() error {
+	ctx, cancel := context.WithTimeout(context.Background(), _Timeout)
+	resp, err := this.client.Get(ctx, this.prefix, etcd.WithPrefix())
+	cancel()
+	if err != nil {
+		return err
+	}
+	keys := map[string]bool{}
+	for _, kv := range resp.Kvs {
+		item := this.item(kv)
+		keys[item.Key] = true
+		this.put(item)
+	}
+	this.lock.RLock()
+	var removed []string
+	for key := range this.items {
+		if !keys[key] {
+			removed = append(removed, key)
+		}
+	}
+	this.lock.RUnlock()
+	for _, key := range removed {
+		this.remove(key, resp.Header.Revision)
+	}
+	this.setRev(resp.Header.Revision)
+	return nil
+}
+
+//watching 未全量读取成功时(启动失败或 revision 被压缩)先重试读取, 再从已处理的 revision 开始 watch
+func (this *Center) watching(ctx context.Context, loaded bool) {
+	for {
+		if !loaded {
+			if err := this.reload(); err != nil {
+				tlog.Errorf("ConfCenter: Reload Dir=%s Error=%s", this.prefix, err.Error())
+			} else {
+				loaded = true
+			}
+		}
+		if loaded {
+			rch := this.client.Watch(ctx, this.prefix, etcd.WithPrefix(), etcd.WithRev(this.getRev()+1))
+			for wresp := range rch {
+				if err := wresp.Err(); err != nil {
+					tlog.Errorf("ConfCenter: Watch Dir=%s Error=%s", this.prefix, err.Error())
+					if wresp.CompactRevision != 0 {
+						loaded = false
+					}
+					break
+				}
+				for _, ev := range wresp.Events {
+					if ev.Type == etcd.EventTypeDelete {
+						this.remove(strings.TrimPrefix(string(ev.Kv.Key), this.prefix), ev.Kv.ModRevision)
+					} else {
+						this.put(this.item(ev.Kv))
+					}
+				}
+				this.setRev(wresp.Header.Revision)
+			}
+		}
+		if ctx.Err() != nil || this.client.Ctx().Err() != nil {
+			return
+		}
+		time.Sleep(_RetryInterval)
+	}
+}
+
+func (this *Center) item(kv *mvccpb.KeyValue) *Item {
+	return &Item{
+		Key:      strings.TrimPrefix(string(kv.Key), this.prefix),
+		Value:    string(kv.Value),
+		Revision: kv.ModRevision,
+		Version:  kv.Version,
+	}
+}
+
+func (this *Center) getRev() int64 {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.rev
+}
+
+func (this *Center) setRev(rev int64) {
+	this.lock.Lock()
+	if rev > this.rev {
+		this.rev = rev
+	}
+	this.lock.Unlock()
+}
+
+//put 新值的 revision 更大时更新并通知绑定者
+func (this *Center) put(item *Item) {
+	this.lock.Lock()
+	if old, ok := this.items[item.Key]; ok && old.Revision >= item.Revision {
+		this.lock.Unlock()
+		return
+	}
+	this.items[item.Key] = item
+	bs := append([]*binding(nil), this.bindings[item.Key]...)
+	this.lock.Unlock()
+
+	tlog.Infof("ConfCenter: Update Key=%s%s Revision=%d Version=%d", this.prefix, item.Key, item.Revision, item.Version)
+	for _, b := range bs {
+		b.notify(item)
+	}
+}
+
+//remove 删除后绑定者保持最后的值, 不会收到通知
+func (this *Center) remove(key string, revision int64) {
+	this.lock.Lock()
+	if old, ok := this.items[key]; ok && old.Revision < revision {
+		delete(this.items, key)
+		tlog.Warningf("ConfCenter: Delete Key=%s%s Revision=%d", this.prefix, key, revision)
+	}
+	this.lock.Unlock()
+}
+
+func (this *binding) notify(item *Item) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if item.Revision <= this.rev {
+		return
+	}
+	this.rev = item.Revision
+	v := reflect.New(this.typ).Interface()
+	if err := decode(item.Value, v); err != nil {
+		tlog.Errorf("ConfCenter: Decode Key=%s Revision=%d Error=%s", item.Key, item.Revision, err.Error())
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tlog.Errorf("ConfCenter: OnChange Key=%s Revision=%d Panic=%v", item.Key, item.Revision, r)
+		}
+	}()
+	this.onChange(v)
+}
+
+//decode 解析 toml, 解析密钥引用并按 validate 标签校验
+func decode(value string, v interface{}) error {
+	if _, err := toml.Decode(value, v); err != nil {
+		return err
+	}
+	if errs := util.ResolveConfigSecrets(v); len(errs) > 0 {
+		return errs
+	}
+	if errs := util.ValidateConfig(v); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package confcenter
+
+import (
+	"common/tlog"
+	"testing"
+)
+
+type poolConfig struct {
+	Size    int  `toml:"size" validate:"min=1,max=1000"`
+	Enabled bool `toml:"enabled"`
+}
+
+func TestBind(t *testing.T) {
+	c := newCenter("test", "api")
+	c.put(&Item{Key: "pool", Value: "size = 10", Revision: 5, Version: 1})
+
+	var got []*poolConfig
+	cur := &poolConfig{}
+	if err := c.Bind("pool", cur, func(v interface{}) {
+		got = append(got, v.(*poolConfig))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if cur.Size != 10 {
+		t.Fatalf("initial value %+v", cur)
+	}
+	//不比绑定时的值新的通知被忽略
+	c.bindings["pool"][0].notify(&Item{Key: "pool", Value: "size = 40", Revision: 5})
+	if len(got) != 0 {
+		t.Fatalf("stale notification %+v", got)
+	}
+	if err := c.Bind("pool", poolConfig{}, nil); err != ErrInvalidType {
+		t.Fatalf("bind non-pointer: %v", err)
+	}
+
+	//旧的 revision 与校验失败的值被忽略
+	c.put(&Item{Key: "pool", Value: "size = 20", Revision: 4})
+	c.put(&Item{Key: "pool", Value: "size = 0", Revision: 6, Version: 2})
+	c.put(&Item{Key: "pool", Value: "size = ", Revision: 7, Version: 3})
+	c.put(&Item{Key: "pool", Value: "size = 30\nenabled = true", Revision: 8, Version: 4})
+	if len(got) != 1 || got[0].Size != 30 || !got[0].Enabled {
+		t.Fatalf("unexpected notifications %+v", got)
+	}
+	if item, _ := c.Item("pool"); item.Revision != 8 || item.Version != 4 {
+		t.Fatalf("unexpected item %+v", item)
+	}
+
+	var p poolConfig
+	if err := c.Get("pool", &p); err != nil || p.Size != 30 {
+		t.Fatalf("get %+v %v", p, err)
+	}
+	c.remove("pool", 7)
+	if _, ok := c.Item("pool"); !ok {
+		t.Fatal("stale delete should be ignored")
+	}
+	c.remove("pool", 9)
+	if err := c.Get("pool", &p); err != ErrNotFound {
+		t.Fatalf("get after delete: %v", err)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	defer tlog.SetLevel(tlog.GetLevel())
+	c := newCenter("test", "api")
+	c.put(&Item{Key: LogKey, Value: `level = "ERROR"`, Revision: 1})
+	if err := c.BindLogLevel(); err != nil {
+		t.Fatal(err)
+	}
+	if tlog.GetLevel() != "ERROR" {
+		t.Fatalf("level %s", tlog.GetLevel())
+	}
+	c.put(&Item{Key: LogKey, Value: "level = \"DEBUG\"\n[packages]\n\"confcenter/\" = \"WARNING\"", Revision: 2})
+	levels := tlog.Levels()
+	defer tlog.SetPackageLevel("confcenter/", "")
+	if levels[""] != "DEBUG" || levels["confcenter/"] != "WARNING" {
+		t.Fatalf("levels %v", levels)
+	}
+}
@@ -0,0 +1,41 @@
+package confcenter
+
+import (
+	"common/tlog"
+)
+
+//LogKey 控制日志级别的配置项
+const LogKey = "log"
+
+//LogConfig LogKey 的内容, eg. level = "DEBUG"
+type LogConfig struct {
+	Level    string            `toml:"level"`
+	Packages map[string]string `toml:"packages"` //按包或文件前缀设置, eg. "discovery/" = "WARNING"
+}
+
+//BindLogLevel 由 LogKey 控制日志级别, 配置项删除后保持最后的级别
+func (this *Center) BindLogLevel() error {
+	var c LogConfig
+	if err := this.Bind(LogKey, &c, func(v interface{}) {
+		applyLogConfig(v.(*LogConfig))
+	}); err != nil {
+		return err
+	}
+	if _, ok := this.Item(LogKey); ok {
+		applyLogConfig(&c)
+	}
+	return nil
+}
+
+func applyLogConfig(c *LogConfig) {
+	if c.Level != "" {
+		if err := tlog.SetLevel(c.Level); err != nil {
+			tlog.Errorf("ConfCenter: Log Level=%s Error=%s", c.Level, err.Error())
+		}
+	}
+	for prefix, level := range c.Packages {
+		if err := tlog.SetPackageLevel(prefix, level); err != nil {
+			tlog.Errorf("ConfCenter: Log Package=%s Level=%s Error=%s", prefix, level, err.Error())
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package datalogger
+
+import (
+	"common/bucket"
+	"common/fileutil"
+	"common/tlog"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	_ArchiveInterval = time.Minute
+	_SuccessSuffix   = ".success" //上传成功标记, 本地与 bucket 各一份
+	_ClosedSuffix    = ".closed"  //分区关闭标记, 之后不会再写入
+)
+
+//closedMarker 分区关闭标记内容, Size 为未压缩的文件大小
+type closedMarker struct {
+	Size int64  `json:"size"`
+	Time string `json:"time"`
+}
+
+//writeClosed 原子写入关闭标记, name 为未压缩的分区文件
+func writeClosed(name string) error {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	b, _ := json.Marshal(&closedMarker{Size: fi.Size(), Time: time.Now().Format("2006-01-02 15:04:05")})
+	return writeFileAtomic(name+_ClosedSuffix, b)
+}
+
+//readClosed 读取关闭标记, 分区未关闭时返回 nil
+func readClosed(name string) *closedMarker {
+	b, err := ioutil.ReadFile(name + _ClosedSuffix)
+	if err != nil {
+		return nil
+	}
+	m := &closedMarker{}
+	if json.Unmarshal(b, m) != nil {
+		return nil
+	}
+	return m
+}
+
+//writeFileAtomic 写临时文件并 fsync 后改名, 读到的文件总是完整的
+func writeFileAtomic(name string, b []byte) error {
+	tmp := name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+//seal 启动时为上次运行遗留的未关闭分区补写关闭标记, 属于当前时间段的文件可能继续追加, 等时间段结束再关闭
+func (l *DataLogger) seal() {
+	if l.tpl.period == "" {
+		return
+	}
+	filepath.Walk(l.c.Dir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || !strings.HasSuffix(name, ".log") || fileutil.Exists(name+_ClosedSuffix) {
+			return nil
+		}
+		if !periodStart(fi.ModTime().In(l.c.Location), l.tpl.period).Before(l.period) {
+			l.active[name] = l.period
+			return nil
+		}
+		if err = writeClosed(name); err != nil {
+			tlog.Error("datalogger: close partition", name, err)
+		}
+		return nil
+	})
+}
+
+//archiveLoop 处理已关闭的分区: 压缩, 上传, 清理过期文件; 失败的下个周期重试
+func (l *DataLogger) archiveLoop() {
+	defer close(l.archiveEnd)
+	tick := time.NewTicker(_ArchiveInterval)
+	defer tick.Stop()
+	for {
+		l.archive()
+		select {
+		case <-l.archiveQuit:
+			return
+		case <-l.rotated:
+		case <-tick.C:
+		}
+	}
+}
+
+func (l *DataLogger) archive() {
+	var files []string
+	filepath.Walk(l.c.Dir, func(name string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() && isPartition(name) &&
+			fileutil.Exists(strings.TrimSuffix(name, ".gz")+_ClosedSuffix) {
+			files = append(files, name)
+		}
+		return nil
+	})
+
+	//有 Reader 时以其保存的位置为准, 读取失败则本轮不清理
+	var done map[string]bool
+	expirable := true
+	if l.c.ReaderOffsets != "" {
+		state, err := loadReaderState(l.c.ReaderOffsets)
+		if err != nil {
+			tlog.Error("datalogger: load reader offsets", l.c.ReaderOffsets, err)
+			expirable = false
+		}
+		done = state.Done
+	}
+
+	for _, name := range files {
+		if l.c.Compress && !strings.HasSuffix(name, ".gz") {
+			if err := fileutil.Gzip(name); err != nil {
+				tlog.Error("datalogger: gzip", name, err)
+				continue
+			}
+			name += ".gz"
+		}
+		if l.c.Bucket != nil && !fileutil.Exists(name+_SuccessSuffix) {
+			if err := l.upload(name); err != nil {
+				tlog.Error("datalogger: upload", name, err)
+				continue
+			}
+		}
+		if expirable {
+			l.expire(name, done)
+		}
+	}
+}
+
+//upload 先传数据文件再传成功标记, 分析任务以标记为准导入
+func (l *DataLogger) upload(name string) error {
+	rel, err := filepath.Rel(l.c.Dir, name)
+	if err != nil {
+		return err
+	}
+	key := l.c.Prefix + filepath.ToSlash(rel)
+	mime := "text/plain"
+	if strings.HasSuffix(name, ".gz") {
+		mime = "application/gzip"
+	}
+	if err = l.c.Bucket.Upload(name, key, mime); err != nil {
+		return err
+	}
+
+	marker := name + _SuccessSuffix
+	f, err := os.OpenFile(marker+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	if err = l.c.Bucket.Upload(marker+".tmp", key+_SuccessSuffix, "text/plain"); err != nil {
+		os.Remove(marker + ".tmp")
+		return err
+	}
+	return os.Rename(marker+".tmp", marker)
+}
+
+//expire 删除超过 MaxAge 天的分区, 配置了上传时只删已上传的, 配置了 ReaderOffsets 时只删 done 中已读完的
+func (l *DataLogger) expire(name string, done map[string]bool) {
+	if l.c.MaxAge <= 0 {
+		return
+	}
+	fi, err := os.Stat(name)
+	if err != nil || time.Since(fi.ModTime()) < time.Duration(l.c.MaxAge)*24*time.Hour {
+		return
+	}
+	if l.c.Bucket != nil && !fileutil.Exists(name+_SuccessSuffix) {
+		return
+	}
+	if l.c.ReaderOffsets != "" {
+		rel, err := filepath.Rel(l.c.Dir, strings.TrimSuffix(name, ".gz"))
+		if err != nil || !done[rel] {
+			return
+		}
+	}
+	if err = os.Remove(name); err != nil {
+		tlog.Error("datalogger: remove", name, err)
+		return
+	}
+	os.Remove(name + _SuccessSuffix)
+	os.Remove(strings.TrimSuffix(name, ".gz") + _ClosedSuffix)
+}
+
+//isPartition 分区数据文件, 排除标记与临时文件
+func isPartition(name string) bool {
+	return strings.HasSuffix(name, ".log") || strings.HasSuffix(name, ".log.gz")
+}
+
+//newBucket 根据配置创建上传客户端, 已通过 Bucket 指定时不覆盖
+func newBucket(c *DataConfig) error {
+	if c.Bucket != nil || c.Upload == nil {
+		return nil
+	}
+	client, err := bucket.NewBucketClient(c.Upload)
+	if err != nil {
+		return err
+	}
+	c.Bucket = client
+	return nil
+}
@@ -1,12 +1,16 @@
 package datalogger
 
 import (
-	"bufio"
 	"bytes"
+	"common/bucket"
+	"common/tlog"
+	"container/list"
+	"errors"
 	"fmt"
 	"os"
-	"path"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,27 +19,71 @@ const (
 	PartitionHour = 1
 )
 
+const (
+	SyncNone   = ""       //每秒写入文件, 不 fsync, 进程崩溃最多丢 1 秒数据
+	SyncSecond = "second" //每秒写入并 fsync, 机器掉电最多丢 1 秒数据
+	SyncAlways = "always" //队列取空后立即写入并 fsync, 崩溃时只丢失仍在队列中的记录
+)
+
+var ErrClosed = errors.New("datalogger: closed")
+var ErrQueueFull = errors.New("datalogger: queue full")
+
 type DataConfig struct {
 	Dir       string         `toml:"dir"`
 	Partition int            `toml:"partition"`
 	Timezone  string         `toml:"timezone"`
 	Location  *time.Location `toml:"-"`
+
+	Format string        `toml:"format"` //空为原始文本行, json 或 csv
+	Schema []FieldConfig `toml:"Schema"` //csv 必填, json 可选
+	Queue  int           `toml:"queue"`  //队列长度, 默认 8192
+	Drop   bool          `toml:"drop"`   //队列满时丢弃而不是阻塞调用方
+	Sync   string        `toml:"sync"`   //fsync 策略, 空/second/always
+
+	Path    string `toml:"path"`     //分区路径模板, 如 {event}/{yyyy}{mm}{dd}/{hh}.log, 为空时按 Partition
+	MaxOpen int    `toml:"max_open"` //同时打开的文件数, 默认 64
+
+	Compress bool                 `toml:"compress"` //分区切换后 gzip 压缩
+	MaxAge   int                  `toml:"max_age"`  //本地分区保留天数, 0 表示不清理
+	Upload   *bucket.BucketConfig `toml:"Upload"`   //上传已切换的分区
+	Prefix   string               `toml:"prefix"`   //上传对象名前缀
+	Bucket   bucket.BucketClient  `toml:"-"`        //直接指定上传客户端, 优先于 Upload
+
+	ReaderOffsets string `toml:"reader_offsets"` //Reader 的 offsetFile, 设置后过期清理只删除 Reader 已读完的分区
 }
 
+//Keys 分区路径模板中键占位符的值
+type Keys map[string]string
+
 type DataLogger struct {
 	c        DataConfig
-	file     string
-	f        *os.File
-	w        *bufio.Writer
-	byteBuff bytes.Buffer
+	schema   *schema
+	tpl      *template
 	bytePool *sync.Pool
 	ch       chan *data
 	timer    *time.Ticker
 	end      chan bool
+	closed   int32
+	dropped  uint64
+
+	//put 持读锁检查 closed 并入队, Close 持写锁设置 closed, 保证 nil 之后不再有记录入队
+	closeLock sync.RWMutex
+
+	//以下仅在写入 goroutine 中使用
+	handles map[string]*handle
+	lru     *list.List
+	active  map[string]time.Time //未关闭的分区 => 所属时间段的开始
+	repl    *strings.Replacer
+	period  time.Time
+
+	rotated     chan struct{}
+	archiveQuit chan struct{}
+	archiveEnd  chan struct{}
 }
 
 type data struct {
-	msg []byte
+	msg  []byte
+	path string
 }
 
 func NewDataLogger(c *DataConfig) (*DataLogger, error) {
@@ -48,120 +96,223 @@ func NewDataLogger(c *DataConfig) (*DataLogger, error) {
 		}
 		c.Location = location
 	}
-
-	l := &DataLogger{
-		c:        *c,
-		bytePool: &sync.Pool{New: func() interface{} { return new(bytes.Buffer) }},
-		ch:       make(chan *data, 8192),
-		timer:    time.NewTicker(time.Second),
-		end:      make(chan bool, 1),
+	if c.Queue <= 0 {
+		c.Queue = 8192
 	}
-
-	err := os.MkdirAll(l.c.Dir, 0755)
-
+	if c.MaxOpen <= 0 {
+		c.MaxOpen = _DefaultMaxOpen
+	}
+	switch c.Sync {
+	case SyncNone, SyncSecond, SyncAlways:
+	default:
+		return nil, fmt.Errorf("datalogger: unknown sync policy %s", c.Sync)
+	}
+	s, err := newSchema(c)
 	if err != nil {
 		return nil, err
 	}
+	tpl, err := newTemplate(c)
+	if err != nil {
+		return nil, err
+	}
+	if err = newBucket(c); err != nil {
+		return nil, err
+	}
 
-	l.refresh()
-	l.f, err = os.OpenFile(l.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	l := &DataLogger{
+		c:           *c,
+		schema:      s,
+		tpl:         tpl,
+		bytePool:    &sync.Pool{New: func() interface{} { return new(bytes.Buffer) }},
+		ch:          make(chan *data, c.Queue),
+		timer:       time.NewTicker(time.Second),
+		end:         make(chan bool, 1),
+		rotated:     make(chan struct{}, 1),
+		archiveQuit: make(chan struct{}),
+		archiveEnd:  make(chan struct{}),
+		handles:     make(map[string]*handle),
+		lru:         list.New(),
+		active:      make(map[string]time.Time),
+	}
+
+	err = os.MkdirAll(l.c.Dir, 0755)
 
 	if err != nil {
 		return nil, err
 	}
 
-	l.w = bufio.NewWriter(l.f)
+	now := time.Now().In(l.c.Location)
+	l.period = periodStart(now, l.tpl.period)
+	l.repl = timeReplacer(now)
+	l.seal()
 	l.run()
 
 	return l, nil
 }
 
 func (l *DataLogger) Log(args ...interface{}) {
-	l.log("", args...)
+	l.LogKeys(nil, args...)
 }
 
 func (l *DataLogger) Logf(format string, args ...interface{}) {
-	l.log(format, args...)
+	l.LogfKeys(nil, format, args...)
+}
+
+//LogKeys 写入 keys 对应的分区, 缺少的键使用 unknown
+func (l *DataLogger) LogKeys(keys Keys, args ...interface{}) {
+	w := l.bytePool.Get().(*bytes.Buffer)
+	for i := 0; i < len(args); i++ {
+		if i > 0 {
+			w.Write([]byte{' '})
+		}
+
+		fmt.Fprint(w, args[i])
+	}
+	l.put(w, l.tpl.resolve(func(key string) string { return keys[key] }))
+}
+
+func (l *DataLogger) LogfKeys(keys Keys, format string, args ...interface{}) {
+	w := l.bytePool.Get().(*bytes.Buffer)
+	fmt.Fprintf(w, format, args...)
+	l.put(w, l.tpl.resolve(func(key string) string { return keys[key] }))
+}
+
+//Write 按 schema 校验并写入一条记录, 分区路径的键取记录中的同名字段; 校验失败或队列满(Drop)时返回错误
+func (l *DataLogger) Write(r Record) error {
+	return l.WriteKeys(nil, r)
+}
+
+//WriteKeys 分区路径的键优先取 keys, 其次取记录中的同名字段
+func (l *DataLogger) WriteKeys(keys Keys, r Record) error {
+	if l.schema.format == FormatText {
+		return errors.New("datalogger: Write requires json or csv format")
+	}
+	w := l.bytePool.Get().(*bytes.Buffer)
+	if err := l.schema.encode(r, w); err != nil {
+		w.Reset()
+		l.bytePool.Put(w)
+		return err
+	}
+	return l.put(w, l.tpl.resolve(func(key string) string {
+		if v, ok := keys[key]; ok {
+			return v
+		}
+		if v, ok := r[key]; ok && v != nil {
+			return fmt.Sprint(v)
+		}
+		return ""
+	}))
+}
+
+//Dropped 队列满被丢弃的条数
+func (l *DataLogger) Dropped() uint64 {
+	return atomic.LoadUint64(&l.dropped)
 }
 
 func (l *DataLogger) Close() {
+	l.closeLock.Lock()
+	if !atomic.CompareAndSwapInt32(&l.closed, 0, 1) {
+		l.closeLock.Unlock()
+		return
+	}
+	l.closeLock.Unlock()
 	l.timer.Stop()
 	l.ch <- nil
-	close(l.ch)
 	<-l.end
+	if l.archiving() {
+		close(l.archiveQuit)
+		<-l.archiveEnd
+	}
 }
 
-func (l *DataLogger) refresh() bool {
-	nowTime := time.Now().In(l.c.Location)
-	year, month, day := nowTime.Date()
-
-	var newfile string
-	switch l.c.Partition {
-	case PartitionHour:
-		hour := nowTime.Hour()
-		newfile = path.Join(l.c.Dir, fmt.Sprintf("%04d%02d%02d.%02d.log", year, month, day, hour))
-	default:
-		newfile = path.Join(l.c.Dir, fmt.Sprintf("%04d%02d%02d.log", year, month, day))
+//open 打开分区文件, 新建的 csv 文件先写表头
+func (l *DataLogger) open(name string) (*os.File, error) {
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
 	}
-
-	if l.file != newfile {
-		l.file = newfile
-		return true
+	if header := l.schema.header(); header != nil {
+		if fi, err := f.Stat(); err == nil && fi.Size() == 0 {
+			f.Write(header)
+		}
 	}
-	return false
+	return f, nil
+}
+
+//archiving 是否需要后台处理已切换的分区
+func (l *DataLogger) archiving() bool {
+	return l.c.Compress || l.c.MaxAge > 0 || l.c.Bucket != nil
 }
 
 func (l *DataLogger) run() {
-	go l.flush()
+	if l.archiving() {
+		go l.archiveLoop()
+	}
 	go l.start()
 }
 
 func (l *DataLogger) start() {
-	for m := range l.ch {
-		if m == nil {
-			l.w.Flush()
-
-			if l.refresh() {
-				l.f.Close()
-				l.f, _ = os.OpenFile(l.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-				l.w.Reset(l.f)
+	for {
+		select {
+		case m := <-l.ch:
+			if m == nil {
+				for l.lru.Len() > 0 {
+					l.closeHandle(l.lru.Front().Value.(*handle))
+				}
+				l.end <- true
+				return
 			}
-		} else {
-			w := &l.byteBuff
-			w.Write(m.msg)
-			w.WriteByte(10)
-			b := w.Bytes()
-			l.w.Write(b)
-			l.byteBuff.Reset()
+			l.write(m)
+			if l.c.Sync == SyncAlways && len(l.ch) == 0 {
+				l.flush(true)
+			}
+		case <-l.timer.C:
+			l.flush(l.c.Sync == SyncSecond)
+			l.rotate(time.Now().In(l.c.Location))
 		}
 	}
-
-	l.end <- true
 }
 
-func (l *DataLogger) flush() {
-	for range l.timer.C {
-		l.ch <- nil
+func (l *DataLogger) write(m *data) {
+	name := l.partitionFile(m.path)
+	h, err := l.handle(name)
+	if err != nil {
+		tlog.Error("datalogger: open", name, err)
+		return
+	}
+	h.w.Write(m.msg)
+	if l.tpl.period != "" {
+		l.active[name] = l.period
 	}
 }
 
-func (l *DataLogger) log(format string, args ...interface{}) {
-	w := l.bytePool.Get().(*bytes.Buffer)
-	if len(format) == 0 {
-		for i := 0; i < len(args); i++ {
-			if i > 0 {
-				w.Write([]byte{' '})
-			}
-
-			fmt.Fprint(w, args[i])
-		}
-	} else {
-		fmt.Fprintf(w, format, args...)
+func (l *DataLogger) flush(sync bool) {
+	for e := l.lru.Front(); e != nil; e = e.Next() {
+		l.flushHandle(e.Value.(*handle), sync)
 	}
+}
+
+func (l *DataLogger) put(w *bytes.Buffer, path string) error {
+	w.WriteByte(10)
 	b := make([]byte, w.Len())
 	copy(b, w.Bytes())
 	w.Reset()
 	l.bytePool.Put(w)
 
-	l.ch <- &data{msg: b}
+	l.closeLock.RLock()
+	defer l.closeLock.RUnlock()
+	if atomic.LoadInt32(&l.closed) == 1 {
+		return ErrClosed
+	}
+	if !l.c.Drop {
+		l.ch <- &data{msg: b, path: path}
+		return nil
+	}
+	select {
+	case l.ch <- &data{msg: b, path: path}:
+		return nil
+	default:
+		atomic.AddUint64(&l.dropped, 1)
+		return ErrQueueFull
+	}
 }
@@ -0,0 +1,198 @@
+package datalogger
+
+import (
+	"bytes"
+	"common/bucket"
+	"common/fileutil"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestSchema(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "datalogger")
+	defer os.RemoveAll(dir)
+
+	l, err := NewDataLogger(&DataConfig{
+		Dir:    dir,
+		Format: FormatCSV,
+		Schema: []FieldConfig{{"uid", TypeInt}, {"name", TypeString}, {"score", TypeFloat}, {"ok", TypeBool}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Write(Record{"uid": 1, "name": "a,b", "score": 1.5, "ok": true}); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Write(Record{"uid": "1"}); err == nil {
+		t.Fatal("want type error")
+	}
+	if err = l.Write(Record{"unknown": 1}); err == nil {
+		t.Fatal("want undeclared field error")
+	}
+	l.Write(Record{"uid": uint32(2)})
+	file := currentFile(l)
+	l.Close()
+
+	b, _ := ioutil.ReadFile(file)
+	want := "uid,name,score,ok\n1,\"a,b\",1.5,true\n2,,,\n"
+	if string(b) != want {
+		t.Fatalf("got %q, want %q", b, want)
+	}
+
+	s, _ := newSchema(&DataConfig{Format: FormatJSON, Schema: []FieldConfig{{"t", TypeTime}, {"v", TypeAny}}, Location: time.UTC})
+	var out bytes.Buffer
+	if err = s.encode(Record{"t": time.Unix(0, 0), "v": []int{1, 2}}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != `{"t":"1970-01-01 00:00:00","v":[1,2]}` {
+		t.Fatal(out.String())
+	}
+}
+
+func TestArchive(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "datalogger")
+	defer os.RemoveAll(dir)
+
+	client, err := bucket.NewBucketClient(&bucket.BucketConfig{Type: "local", Region: dir, Bucket: "bucket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := path.Join(dir, "data")
+	os.MkdirAll(data, 0755)
+	old := path.Join(data, "20200101.log")
+	ioutil.WriteFile(old, []byte("hello\n"), 0644)
+	past := time.Now().Add(-72 * time.Hour)
+	os.Chtimes(old, past, past)
+
+	l, err := NewDataLogger(&DataConfig{Dir: data, Compress: true, MaxAge: 1, Bucket: client, Prefix: "app/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Log("now")
+	l.Close()
+
+	//已上传且过期的分区被清理, 当前分区保留
+	if fileutil.Exists(old) || fileutil.Exists(old+".gz") || fileutil.Exists(old+".gz"+_SuccessSuffix) {
+		t.Fatal("old partition not archived")
+	}
+	for _, key := range []string{"app/20200101.log.gz", "app/20200101.log.gz" + _SuccessSuffix} {
+		if client.Exists(key) != nil {
+			t.Fatal("not uploaded:", key)
+		}
+	}
+	if !fileutil.Exists(currentFile(l)) {
+		t.Fatal("current partition removed")
+	}
+
+	//配置了 ReaderOffsets 时, Reader 未读完的分区不清理
+	unread := path.Join(data, "20200102.log")
+	ioutil.WriteFile(unread, []byte("hello\n"), 0644)
+	writeClosed(unread)
+	os.Chtimes(unread, past, past)
+	offsets := path.Join(dir, "offsets.json")
+	c := DataConfig{Dir: data, MaxAge: 1, ReaderOffsets: offsets}
+	l, _ = NewDataLogger(&c)
+	l.Close()
+	if !fileutil.Exists(unread) {
+		t.Fatal("unread partition removed")
+	}
+	ioutil.WriteFile(offsets, []byte(`{"done":{"20200102.log":true}}`), 0644)
+	l, _ = NewDataLogger(&c)
+	l.Close()
+	if fileutil.Exists(unread) {
+		t.Fatal("read partition not removed")
+	}
+}
+
+func TestReader(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "datalogger")
+	defer os.RemoveAll(dir)
+	data := path.Join(dir, "data")
+	offsets := path.Join(dir, "offsets.json")
+
+	l, err := NewDataLogger(&DataConfig{Dir: data, Sync: SyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Log("a")
+	l.Log("b")
+	time.Sleep(50 * time.Millisecond)
+
+	r, _ := NewReader(data, offsets)
+	b, err := r.Read(10)
+	if err != nil || b == nil || len(b.Lines) != 2 || b.Last {
+		t.Fatalf("first batch %+v %v", b, err)
+	}
+	r.Commit(b)
+	if b, _ = r.Read(10); b != nil {
+		t.Fatalf("want no data, got %+v", b)
+	}
+
+	l.Log("c")
+	file := currentFile(l)
+	l.Close()
+	writeClosed(file)
+
+	//重新打开后从保存的位置继续, 读完关闭的分区后不再读取
+	r, _ = NewReader(data, offsets)
+	b, _ = r.Read(10)
+	if b == nil || len(b.Lines) != 1 || string(b.Lines[0]) != "c" || !b.Last {
+		t.Fatalf("second batch %+v", b)
+	}
+	r.Commit(b)
+	if b, _ = r.Read(10); b != nil {
+		t.Fatalf("want done, got %+v", b)
+	}
+}
+
+func currentFile(l *DataLogger) string {
+	return l.partitionFile(l.tpl.resolve(func(string) string { return "" }))
+}
+
+func TestPartition(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "datalogger")
+	defer os.RemoveAll(dir)
+
+	c := DataConfig{Dir: dir, Format: FormatJSON, Path: "{event}/{yyyy}{mm}{dd}/{hh}.log", MaxOpen: 1}
+	l, err := NewDataLogger(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//MaxOpen 为 1, 交替写入会反复淘汰句柄
+	for i := 0; i < 3; i++ {
+		l.Write(Record{"event": "login", "i": i})
+		l.Write(Record{"event": "pay", "i": i})
+	}
+	l.WriteKeys(Keys{"event": "../etc"}, Record{"i": 0})
+	l.Log("no key")
+	l.Close()
+
+	//路径由 logger 按其当前时间段计算, 避免测试跨整点时与写入时的时间段不一致
+	files := map[string]string{}
+	for _, event := range []string{"login", "pay", "../etc", ""} {
+		files[event] = l.partitionFile(l.tpl.resolve(func(string) string { return event }))
+	}
+	for event, lines := range map[string]int{"login": 3, "pay": 3, "../etc": 1, "": 1} {
+		name := files[event]
+		b, err := ioutil.ReadFile(name)
+		if err != nil || bytes.Count(b, []byte{'\n'}) != lines {
+			t.Fatalf("%s: %q %v", name, b, err)
+		}
+	}
+
+	//上一个时间段的文件在启动时关闭
+	login := files["login"]
+	past := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(login, past, past)
+	l, _ = NewDataLogger(&c)
+	l.Close()
+	if readClosed(login) == nil {
+		t.Fatal("old partition not sealed")
+	}
+	if readClosed(files["pay"]) != nil {
+		t.Fatal("current partition sealed")
+	}
+}
@@ -0,0 +1,208 @@
+package datalogger
+
+import (
+	"bufio"
+	"common/tlog"
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	_DefaultMaxOpen = 64
+	_UnknownKey     = "unknown"
+)
+
+var placeholder = regexp.MustCompile(`\{(\w+)\}`)
+
+//时间占位符, 其余占位符由记录的键填充
+var timeHolders = map[string]bool{"yyyy": true, "mm": true, "dd": true, "hh": true}
+
+//template 分区路径模板, 如 {event}/{yyyy}{mm}{dd}/{hh}.log
+type template struct {
+	segments []segment
+	keys     []string
+	period   string //最小的时间单位 hh/dd/mm/yyyy, 空表示不按时间切换
+}
+
+type segment struct {
+	text string
+	key  string //非空时为键占位符
+}
+
+func newTemplate(c *DataConfig) (*template, error) {
+	path := c.Path
+	if path == "" {
+		path = "{yyyy}{mm}{dd}.log"
+		if c.Partition == PartitionHour {
+			path = "{yyyy}{mm}{dd}.{hh}.log"
+		}
+	}
+	if !strings.HasSuffix(path, ".log") || filepath.IsAbs(path) || strings.Contains(path, "..") {
+		return nil, fmt.Errorf("datalogger: invalid path %s, must be relative and end with .log", path)
+	}
+
+	t := &template{}
+	last := 0
+	for _, m := range placeholder.FindAllStringSubmatchIndex(path, -1) {
+		name := path[m[2]:m[3]]
+		if timeHolders[name] {
+			t.period = finerPeriod(t.period, name)
+			continue
+		}
+		t.segments = append(t.segments, segment{text: path[last:m[0]]}, segment{key: name})
+		t.keys = append(t.keys, name)
+		last = m[1]
+	}
+	t.segments = append(t.segments, segment{text: path[last:]})
+	return t, nil
+}
+
+func finerPeriod(a string, b string) string {
+	order := map[string]int{"": 0, "yyyy": 1, "mm": 2, "dd": 3, "hh": 4}
+	if order[b] > order[a] {
+		return b
+	}
+	return a
+}
+
+//resolve 填充键占位符, 时间占位符留给写入时处理; 键值中的路径字符会被替换
+func (this *template) resolve(value func(key string) string) string {
+	if len(this.keys) == 0 {
+		return this.segments[0].text
+	}
+	var b strings.Builder
+	for _, s := range this.segments {
+		if s.key == "" {
+			b.WriteString(s.text)
+		} else {
+			b.WriteString(cleanKey(value(s.key)))
+		}
+	}
+	return b.String()
+}
+
+func cleanKey(v string) string {
+	if v == "" || v == "." || v == ".." {
+		return _UnknownKey
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, v)
+}
+
+//timeReplacer 当前时间的占位符替换
+func timeReplacer(now time.Time) *strings.Replacer {
+	year, month, day := now.Date()
+	return strings.NewReplacer(
+		"{yyyy}", fmt.Sprintf("%04d", year),
+		"{mm}", fmt.Sprintf("%02d", month),
+		"{dd}", fmt.Sprintf("%02d", day),
+		"{hh}", fmt.Sprintf("%02d", now.Hour()))
+}
+
+//periodStart 所在时间段的开始, 用于判断文件是否属于已结束的分区
+func periodStart(t time.Time, period string) time.Time {
+	year, month, day := t.Date()
+	switch period {
+	case "hh":
+		return time.Date(year, month, day, t.Hour(), 0, 0, 0, t.Location())
+	case "dd":
+		return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	case "mm":
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	case "yyyy":
+		return time.Date(year, 1, 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Time{}
+}
+
+//handle 打开的分区文件, 按最近使用排序, 超过 MaxOpen 时关闭最久未用的
+type handle struct {
+	name string
+	f    *os.File
+	w    *bufio.Writer
+	elem *list.Element
+}
+
+//handle 返回分区文件的句柄, 必要时打开并淘汰最久未用的句柄
+func (l *DataLogger) handle(name string) (*handle, error) {
+	if h, ok := l.handles[name]; ok {
+		l.lru.MoveToFront(h.elem)
+		return h, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+	f, err := l.open(name)
+	if err != nil {
+		return nil, err
+	}
+	h := &handle{name: name, f: f, w: bufio.NewWriter(f)}
+	h.elem = l.lru.PushFront(h)
+	l.handles[name] = h
+	for l.lru.Len() > l.c.MaxOpen {
+		l.closeHandle(l.lru.Back().Value.(*handle))
+	}
+	return h, nil
+}
+
+func (l *DataLogger) closeHandle(h *handle) {
+	l.flushHandle(h, l.c.Sync != SyncNone)
+	h.f.Close()
+	l.lru.Remove(h.elem)
+	delete(l.handles, h.name)
+}
+
+func (l *DataLogger) flushHandle(h *handle, sync bool) {
+	if err := h.w.Flush(); err != nil {
+		tlog.Error("datalogger: write", h.name, err)
+	}
+	if sync {
+		h.f.Sync()
+	}
+}
+
+//rotate 时间段切换后关闭之前时间段的分区并写入关闭标记, 通知 archiveLoop 处理
+func (l *DataLogger) rotate(now time.Time) {
+	start := periodStart(now, l.tpl.period)
+	if !start.After(l.period) {
+		return
+	}
+	l.period = start
+	l.repl = timeReplacer(now)
+
+	sealed := false
+	for name, period := range l.active {
+		if !period.Before(start) {
+			continue
+		}
+		if h, ok := l.handles[name]; ok {
+			l.flushHandle(h, true)
+			l.closeHandle(h)
+		}
+		if err := writeClosed(name); err != nil {
+			tlog.Error("datalogger: close partition", name, err)
+		}
+		delete(l.active, name)
+		sealed = true
+	}
+	if sealed {
+		select {
+		case l.rotated <- struct{}{}:
+		default:
+		}
+	}
+}
+
+//partitionFile 键已填充的路径在当前时间段对应的文件
+func (l *DataLogger) partitionFile(path string) string {
+	return filepath.Join(l.c.Dir, l.repl.Replace(path))
+}
@@ -0,0 +1,196 @@
+package datalogger
+
+import (
+	"bufio"
+	"bytes"
+	"common/fileutil"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//Reader 按分区顺序读取 DataLogger 的输出并持久化读取位置, 可以跟随正在写入的分区
+//
+//读取流程: Read 取一批 -> 投递到下游 -> Commit 保存位置.
+//Commit 之前崩溃会重新读到同一批, 下游按 Batch.Partition + Batch.Offset 去重即可做到恰好一次
+type Reader struct {
+	dir        string
+	offsetFile string
+	state      readerState
+}
+
+type readerState struct {
+	Offsets map[string]int64 `json:"offsets"` //分区相对路径 => 已提交的未压缩字节数
+	Done    map[string]bool  `json:"done"`    //已读完的关闭分区
+}
+
+//Batch 一次读到的连续完整行
+type Batch struct {
+	Partition string   //分区相对路径, 不含 .gz
+	Offset    int64    //第一行在分区中的字节位置
+	Next      int64    //下一批的起始位置, Commit 时保存
+	Lines     [][]byte //不含换行符
+	Last      bool     //分区已关闭且本批读到末尾, Commit 后不再读取
+}
+
+//NewReader offsetFile 保存读取位置, 不存在时从头读取
+func NewReader(dir string, offsetFile string) (*Reader, error) {
+	state, err := loadReaderState(offsetFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{dir: dir, offsetFile: offsetFile, state: state}, nil
+}
+
+//loadReaderState 读取 Reader 保存的位置, 文件不存在时为空
+func loadReaderState(offsetFile string) (readerState, error) {
+	var state readerState
+	b, err := ioutil.ReadFile(offsetFile)
+	if err == nil {
+		err = json.Unmarshal(b, &state)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if state.Offsets == nil {
+		state.Offsets = make(map[string]int64)
+	}
+	if state.Done == nil {
+		state.Done = make(map[string]bool)
+	}
+	return state, nil
+}
+
+//Read 按分区名顺序找到第一个有新数据的分区, 最多返回 max 行; 没有新数据时返回 nil
+func (r *Reader) Read(max int) (*Batch, error) {
+	for _, p := range r.partitions() {
+		if r.state.Done[p] {
+			continue
+		}
+		b, err := r.read(p, max)
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			continue
+		}
+		if len(b.Lines) == 0 {
+			//关闭的分区已读完, 直接标记
+			if err = r.Commit(b); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return b, nil
+	}
+	return nil, nil
+}
+
+//Commit 下游处理成功后保存读取位置
+func (r *Reader) Commit(b *Batch) error {
+	r.state.Offsets[b.Partition] = b.Next
+	if b.Last {
+		r.state.Done[b.Partition] = true
+		delete(r.state.Offsets, b.Partition)
+	}
+	//分区被清理后不再需要记录
+	for p := range r.state.Done {
+		name := filepath.Join(r.dir, p)
+		if !fileutil.Exists(name) && !fileutil.Exists(name+".gz") {
+			delete(r.state.Done, p)
+		}
+	}
+	data, err := json.Marshal(&r.state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(r.offsetFile, data)
+}
+
+//partitions 按名称排序的分区相对路径, 压缩的分区去掉 .gz
+func (r *Reader) partitions() []string {
+	var names []string
+	seen := make(map[string]bool)
+	filepath.Walk(r.dir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || !isPartition(name) {
+			return nil
+		}
+		rel, err := filepath.Rel(r.dir, strings.TrimSuffix(name, ".gz"))
+		if err == nil && !seen[rel] {
+			seen[rel] = true
+			names = append(names, rel)
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names
+}
+
+//read 从已提交的位置读取完整的行; 未关闭的分区末尾不完整的行留到下次
+func (r *Reader) read(p string, max int) (*Batch, error) {
+	name := filepath.Join(r.dir, p)
+	//先读关闭标记再读数据, 保证读到的数据不少于标记中的大小
+	closed := readClosed(name)
+	offset := r.state.Offsets[p]
+
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		if closed == nil {
+			return nil, nil
+		}
+		f, err = os.Open(name + ".gz")
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var src io.Reader = f
+	if strings.HasSuffix(f.Name(), ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		if _, err = io.CopyN(ioutil.Discard, zr, offset); err != nil {
+			return nil, err
+		}
+		src = zr
+	} else if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	b := &Batch{Partition: p, Offset: offset, Next: offset}
+	br := bufio.NewReader(src)
+	for len(b.Lines) < max {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			//关闭的分区以标记中的大小为准, 进程崩溃留下的不完整行也输出
+			if closed != nil && len(line) > 0 && b.Next+int64(len(line)) <= closed.Size {
+				b.Lines = append(b.Lines, line)
+				b.Next += int64(len(line))
+			}
+			b.Last = closed != nil && b.Next >= closed.Size
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		b.Next += int64(len(line))
+		b.Lines = append(b.Lines, bytes.TrimSuffix(line, []byte{'\n'}))
+	}
+	if len(b.Lines) == 0 && !b.Last {
+		return nil, nil
+	}
+	return b, nil
+}
@@ -0,0 +1,226 @@
+package datalogger
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	FormatText = ""
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeBool   = "bool"
+	TypeTime   = "time" //time.Time 或 unix 秒, 按 Timezone 输出 2006-01-02 15:04:05
+	TypeAny    = "any"  //仅 json, 原样序列化
+)
+
+var ErrNoSchema = errors.New("datalogger: csv format requires schema")
+
+//Record 一条结构化记录, 字段名 => 值
+type Record map[string]interface{}
+
+//FieldConfig 记录字段声明, 按声明顺序输出
+type FieldConfig struct {
+	Name string `toml:"name"`
+	Type string `toml:"type"`
+}
+
+type schema struct {
+	format   string
+	fields   []FieldConfig
+	index    map[string]int
+	location *time.Location
+}
+
+func newSchema(c *DataConfig) (*schema, error) {
+	s := &schema{format: c.Format, fields: c.Schema, index: make(map[string]int), location: c.Location}
+	switch c.Format {
+	case FormatText, FormatJSON:
+	case FormatCSV:
+		if len(c.Schema) == 0 {
+			return nil, ErrNoSchema
+		}
+	default:
+		return nil, fmt.Errorf("datalogger: unknown format %s", c.Format)
+	}
+	for i, f := range c.Schema {
+		switch f.Type {
+		case TypeString, TypeInt, TypeFloat, TypeBool, TypeTime:
+		case TypeAny:
+			if c.Format == FormatCSV {
+				return nil, fmt.Errorf("datalogger: field %s, type any is not supported by csv", f.Name)
+			}
+		default:
+			return nil, fmt.Errorf("datalogger: field %s, unknown type %s", f.Name, f.Type)
+		}
+		if _, ok := s.index[f.Name]; ok {
+			return nil, fmt.Errorf("datalogger: duplicate field %s", f.Name)
+		}
+		s.index[f.Name] = i
+	}
+	return s, nil
+}
+
+//header CSV 文件首行
+func (this *schema) header() []byte {
+	if this.format != FormatCSV {
+		return nil
+	}
+	names := make([]string, len(this.fields))
+	for i, f := range this.fields {
+		names[i] = f.Name
+	}
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write(names)
+	w.Flush()
+	return b.Bytes()
+}
+
+//encode 校验并编码一条记录, 不含换行; 未声明的字段报错, 缺少的字段输出空值
+func (this *schema) encode(r Record, w *bytes.Buffer) error {
+	if len(this.fields) == 0 {
+		//没有声明 schema 的 json 按字段名排序输出
+		keys := make([]string, 0, len(r))
+		for k := range r {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		w.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			if err := writeJSON(w, k); err != nil {
+				return err
+			}
+			w.WriteByte(':')
+			if err := writeJSON(w, r[k]); err != nil {
+				return fmt.Errorf("datalogger: field %s, %s", k, err.Error())
+			}
+		}
+		w.WriteByte('}')
+		return nil
+	}
+
+	for k := range r {
+		if _, ok := this.index[k]; !ok {
+			return fmt.Errorf("datalogger: undeclared field %s", k)
+		}
+	}
+	values := make([]interface{}, len(this.fields))
+	for i, f := range this.fields {
+		v, ok := r[f.Name]
+		if !ok || v == nil {
+			continue
+		}
+		cv, err := this.convert(f.Type, v)
+		if err != nil {
+			return fmt.Errorf("datalogger: field %s, %s", f.Name, err.Error())
+		}
+		values[i] = cv
+	}
+
+	if this.format == FormatCSV {
+		row := make([]string, len(values))
+		for i, v := range values {
+			switch cv := v.(type) {
+			case nil:
+			case float64:
+				row[i] = strconv.FormatFloat(cv, 'f', -1, 64)
+			default:
+				row[i] = fmt.Sprint(cv)
+			}
+		}
+		cw := csv.NewWriter(w)
+		cw.Write(row)
+		cw.Flush()
+		//csv.Writer 会追加换行, 统一由写入方添加
+		w.Truncate(w.Len() - 1)
+		return cw.Error()
+	}
+
+	w.WriteByte('{')
+	for i, f := range this.fields {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		writeJSON(w, f.Name)
+		w.WriteByte(':')
+		if err := writeJSON(w, values[i]); err != nil {
+			return fmt.Errorf("datalogger: field %s, %s", f.Name, err.Error())
+		}
+	}
+	w.WriteByte('}')
+	return nil
+}
+
+//convert 按声明类型检查并转换值
+func (this *schema) convert(typ string, v interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(v)
+	switch typ {
+	case TypeString:
+		switch s := v.(type) {
+		case string:
+			return s, nil
+		case []byte:
+			return string(s), nil
+		case fmt.Stringer:
+			return s.String(), nil
+		}
+	case TypeInt:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return rv.Int(), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return rv.Uint(), nil
+		}
+	case TypeFloat:
+		switch rv.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return rv.Float(), nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return rv.Int(), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return rv.Uint(), nil
+		}
+	case TypeBool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case TypeTime:
+		switch t := v.(type) {
+		case time.Time:
+			return t.In(this.location).Format("2006-01-02 15:04:05"), nil
+		case int64:
+			return time.Unix(t, 0).In(this.location).Format("2006-01-02 15:04:05"), nil
+		case int:
+			return time.Unix(int64(t), 0).In(this.location).Format("2006-01-02 15:04:05"), nil
+		}
+	case TypeAny:
+		return v, nil
+	}
+	return nil, fmt.Errorf("want %s, got %T", typ, v)
+}
+
+func writeJSON(w *bytes.Buffer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Write(b)
+	return nil
+}
@@ -25,6 +25,9 @@ const (
 	PlatformIos       = 6
 
 	CtxKeyHashKey = "hash_key"
+
+	HeaderRequestId = "X-Request-Id"
+	HeaderTraceId   = "X-Trace-Id"
 )
 
 var SessionCookie = map[string]string{
@@ -38,6 +38,11 @@ func ContextWithStandard() (context.Context, context.CancelFunc) {
 	return context.WithTimeout(context.Background(), GRPC_TIMEOUT)
 }
 
+//ContextWithParent 继承 parent 中的日志字段等信息, eg. project.LogCtx(c)
+func ContextWithParent(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, GRPC_TIMEOUT)
+}
+
 func ContextWithLongTimeout() (context.Context, context.CancelFunc) {
 	return context.WithTimeout(context.Background(), GRPC_LONG_TIMEOUT)
 }
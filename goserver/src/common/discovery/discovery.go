@@ -13,6 +13,7 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -29,6 +30,8 @@ const (
 	_DirectoryInterval = 10 * time.Second
 	//如果没有心跳, 10 秒钟后节点就会被删除
 	_NodeExpires = 10
+	//订阅时读取 etcd 的超时时间, 超时则回退到本地快照
+	_SubscribeTimeout = 3 * time.Second
 )
 
 var (
@@ -36,11 +39,20 @@ var (
 	etcdClient *etcd.Client
 	//是否关停
 	etcdClientClosed bool
+	//是否已注销本进程注册的节点, 注销后不再续约与重新注册
+	deregistered int32
 
+	//保护 registers 与 subscribes, 管理端口会并发读取
+	stateLock sync.RWMutex
 	//当前进程注册的服务, 记录是为了销毁
 	registers = map[string]*Service{}
-	//service_name => [addr]service
-	subscribes = map[string]*ResolverNode{}
+	//service_name => etcd 订阅或 static/dns 节点
+	subscribes = map[string]subscriber{}
+
+	//订阅时全量读取目录, 测试中替换以模拟 etcd 不可用
+	etcdGet = func(ctx context.Context, key string, opts ...etcd.OpOption) (*etcd.GetResponse, error) {
+		return etcdClient.Get(ctx, key, opts...)
+	}
 )
 
 //Service 一个独立的服务, 被注册, 被发现, 被调用
@@ -49,7 +61,8 @@ type Service struct {
 	Host string `json:"host"` //所属机器的 hostname, 不填的话, 程序会尝试自动填充
 	Addr string `json:"addr"` //{ip}:{port}, 不填写 ip 则会自动填充, 或使用 hostname 替代 ip
 
-	env string //所属环境
+	env    string //所属环境
+	leased int32  //租约是否有效, 续约中断重新注册期间为 0
 }
 
 //一个节点, 用于负载均衡
@@ -60,6 +73,9 @@ type ResolverNode struct {
 	dir          string
 	services     []*Service
 	resolverConn resolver.ClientConn
+
+	stale bool   //当前节点列表来自本地快照, 尚未被 etcd 确认
+	sign  string //最后一次写入快照的节点签名
 }
 
 //Init 初始化
@@ -71,10 +87,20 @@ func Init(Endpoints ...string) {
 		DialTimeout: 5 * time.Second,
 	}
 	if etcdClient, err = etcd.New(etcdConfig); err != nil {
-		panic(err)
+		//etcd 暂不可用时改为后台连接, 订阅会回退到本地快照, etcd 恢复后自动刷新
+		tlog.Errorf("Init: Etcd Error='%s' Endpoints=%v, Dial In Background", err.Error(), Endpoints)
+		etcdConfig.DialTimeout = 0
+		if etcdClient, err = etcd.New(etcdConfig); err != nil {
+			panic(err)
+		}
 	}
 }
 
+//Client Init 创建的 etcd 连接, 供配置中心等复用, 未 Init 时为 nil
+func Client() *etcd.Client {
+	return etcdClient
+}
+
 //Register 要注册的服务
 func Register(env string, s *Service) {
 	if env == "" {
@@ -111,21 +137,35 @@ func Register(env string, s *Service) {
 	if leaseID, err := s.register(); err != nil {
 		panic(fmt.Sprintf("Register: Lease Error=%s", err.Error()))
 	} else {
+		atomic.StoreInt32(&s.leased, 1)
+		stateLock.Lock()
 		registers[s.Name] = s
+		stateLock.Unlock()
 		go s.keepalive(leaseID)
 	}
 }
 
-//Close 关闭和清理
-func Close() {
-	tlog.Info("Close")
-	etcdClientClosed = true
+//Deregister 删除本进程注册的节点并停止续约, etcd 连接与订阅保持可用, 退出前再调用 Close
+func Deregister() {
+	if !atomic.CompareAndSwapInt32(&deregistered, 0, 1) {
+		return
+	}
+	tlog.Info("Deregister")
+	stateLock.RLock()
+	defer stateLock.RUnlock()
 	for _, s := range registers {
 		etcdClient.Delete(
 			context.Background(),
 			fmt.Sprintf(_DirectoryFormat, s.env, s.Name)+s.Addr,
 		)
 	}
+}
+
+//Close 注销节点并关闭 etcd 连接
+func Close() {
+	Deregister()
+	tlog.Info("Close")
+	etcdClientClosed = true
 	if err := etcdClient.Close(); err != nil {
 		tlog.Error(err)
 	}
@@ -201,8 +241,18 @@ func (this *ResolverNode) updateResolverState() {
 }
 
 func (this *ResolverNode) subscribe(immediately bool) {
-	resp, err := etcdClient.Get(context.TODO(), this.dir, etcd.WithPrefix())
+	ctx, cancel := context.WithTimeout(context.Background(), _SubscribeTimeout)
+	resp, err := etcdGet(ctx, this.dir, etcd.WithPrefix())
+	cancel()
 	if err != nil {
+		switch this.restore() {
+		case _KeepNodes:
+			tlog.Errorf("Subscribe: Error='%s' Dir=%s, Keep Current Nodes", err.Error(), this.dir)
+			return
+		case _UseSnapshot:
+			tlog.Errorf("Subscribe: Error='%s' Dir=%s, Use Stale Snapshot", err.Error(), this.dir)
+			return
+		}
 		if immediately {
 			panic(fmt.Sprintf("Subscribe: Error='%s' Dir=%s", err.Error(), this.dir))
 		} else {
@@ -210,13 +260,31 @@ func (this *ResolverNode) subscribe(immediately bool) {
 		}
 		return
 	}
-	for _, n := range resp.Kvs {
-		s := new(Service)
-		if err = json.Unmarshal(n.Value, s); err == nil {
-			err = this.hang(s)
+
+	this.lock.Lock()
+	stale := this.stale
+	this.lock.Unlock()
+	if stale {
+		//etcd 恢复, 以 etcd 的数据替换快照中的节点
+		services := []*Service{}
+		for _, n := range resp.Kvs {
+			s := new(Service)
+			if err = json.Unmarshal(n.Value, s); err != nil {
+				tlog.Infof("Subscribe: Node=%s, Error=%s", string(n.Value), err.Error())
+				continue
+			}
+			services = append(services, s)
 		}
-		if err != nil {
-			tlog.Infof("Subscribe: Node=%s, Error=%s", string(n.Value), err.Error())
+		this.reset(services)
+	} else {
+		for _, n := range resp.Kvs {
+			s := new(Service)
+			if err = json.Unmarshal(n.Value, s); err == nil {
+				err = this.hang(s)
+			}
+			if err != nil {
+				tlog.Infof("Subscribe: Node=%s, Error=%s", string(n.Value), err.Error())
+			}
 		}
 	}
 
@@ -226,6 +294,70 @@ func (this *ResolverNode) subscribe(immediately bool) {
 		} else {
 			tlog.Errorf("Subscribe: Error='%s' Nodes Was Empty", this.dir)
 		}
+		return
+	}
+	this.save()
+}
+
+//restore 的结果
+const (
+	_NoNodes     = iota //没有可用节点
+	_KeepNodes          //已有节点, 保持不变
+	_UseSnapshot        //从本地快照恢复
+)
+
+//restore etcd 不可用时从本地快照恢复节点, 已有可用节点则保持不变
+func (this *ResolverNode) restore() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if len(this.services) > 0 {
+		return _KeepNodes
+	}
+	snap, err := loadSnapshot(this.dir)
+	if err != nil || len(snap.Services) == 0 {
+		if err != nil && !os.IsNotExist(err) {
+			tlog.Errorf("Snapshot: Load Error='%s' Dir=%s", err.Error(), this.dir)
+		}
+		return _NoNodes
+	}
+	this.services = snap.Services
+	this.stale = true
+	this.sign = snapshotSign(snap.Services)
+	tlog.Warningf("Snapshot: Restore Dir=%s Nodes=%d SavedAt=%s", this.dir, len(snap.Services),
+		util.FormatFullTime(time.Unix(snap.Time, 0)))
+
+	this.updateResolverState()
+	return _UseSnapshot
+}
+
+//reset 使用 etcd 返回的完整节点列表替换当前节点
+func (this *ResolverNode) reset(services []*Service) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.services = services
+	this.stale = false
+	tlog.Infof("Snapshot: Refreshed By Etcd Dir=%s Nodes=%d", this.dir, len(services))
+
+	this.updateResolverState()
+}
+
+//save 节点有变化时写入本地快照
+func (this *ResolverNode) save() {
+	this.lock.Lock()
+	sign := snapshotSign(this.services)
+	if this.stale || sign == this.sign {
+		this.lock.Unlock()
+		return
+	}
+	services := make([]*Service, len(this.services))
+	copy(services, this.services)
+	this.sign = sign
+	this.lock.Unlock()
+
+	if err := saveSnapshot(this.dir, services); err != nil {
+		tlog.Errorf("Snapshot: Save Error='%s' Dir=%s", err.Error(), this.dir)
 	}
 }
 
@@ -287,23 +419,26 @@ func (s *Service) register() (etcd.LeaseID, error) {
 
 func (s *Service) keepalive(id etcd.LeaseID) {
 	for {
-		if etcdClientClosed {
+		if etcdClientClosed || atomic.LoadInt32(&deregistered) == 1 {
 			return
 		}
 		tlog.Infof("Keepalive Env=%s Service=%s Addr=%s", s.env, s.Name, s.Addr)
 		keepRespChan, err := etcdClient.KeepAlive(context.TODO(), id)
 		if err == nil {
+			atomic.StoreInt32(&s.leased, 1)
 			for keepResp := range keepRespChan {
 				if keepResp == nil {
 					break
 				}
 			}
+			atomic.StoreInt32(&s.leased, 0)
 			tlog.Infof("Keepalive Over By Channel Closed, Will Retry, Service=%+v", s)
 		} else {
+			atomic.StoreInt32(&s.leased, 0)
 			time.Sleep(time.Second)
 			tlog.Infof("Keepalive Retry, Service=%+v, Error=%v", s, err)
 		}
-		if etcdClientClosed {
+		if etcdClientClosed || atomic.LoadInt32(&deregistered) == 1 {
 			return
 		}
 		if id, err = s.register(); err != nil {
@@ -0,0 +1,90 @@
+package discovery
+
+//gRPC 拦截器: 在服务间传递 request id / trace id / uid / 平台等日志字段
+
+import (
+	"common/tlog"
+	"common/util"
+	"context"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+//日志字段在 gRPC metadata 中的 key
+var logMetadataKeys = map[string]string{
+	tlog.KeyRequestId: "x-request-id",
+	tlog.KeyTraceId:   "x-trace-id",
+	tlog.KeyUid:       "x-uid",
+	tlog.KeyPlatform:  "x-platform",
+}
+
+//UnaryClientInterceptor 将 context 中的日志字段写入请求的 metadata
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{},
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	var kv []string
+	for _, f := range tlog.FromContext(ctx) {
+		if mdKey, ok := logMetadataKeys[f.Key]; ok && f.Value != nil {
+			switch v := f.Value.(type) {
+			case string:
+				kv = append(kv, mdKey, v)
+			case int64:
+				kv = append(kv, mdKey, strconv.FormatInt(v, 10))
+			case int32:
+				kv = append(kv, mdKey, strconv.FormatInt(int64(v), 10))
+			}
+		}
+	}
+	if len(kv) > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, kv...)
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+//UnaryServerInterceptor 从 metadata 中恢复日志字段, 缺少 request id 时生成一个
+func UnaryServerInterceptor(ctx context.Context, req interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	get := func(key string) string {
+		if vals := md.Get(logMetadataKeys[key]); len(vals) > 0 {
+			return vals[0]
+		}
+		return ""
+	}
+
+	requestId := get(tlog.KeyRequestId)
+	if requestId == "" {
+		requestId = util.GenerateUUID()
+	}
+	traceId := get(tlog.KeyTraceId)
+	if traceId == "" {
+		traceId = requestId
+	}
+	fields := []tlog.Field{
+		tlog.String(tlog.KeyRequestId, requestId),
+		tlog.String(tlog.KeyTraceId, traceId),
+	}
+	if uid, err := strconv.ParseInt(get(tlog.KeyUid), 10, 64); err == nil {
+		fields = append(fields, tlog.Int64(tlog.KeyUid, uid))
+	}
+	if platform, err := strconv.ParseInt(get(tlog.KeyPlatform), 10, 32); err == nil {
+		fields = append(fields, tlog.Int32(tlog.KeyPlatform, int32(platform)))
+	}
+	return handler(tlog.NewContext(ctx, fields...), req)
+}
+
+//ChainUnaryServer 依次执行多个拦截器, 当前的 grpc 版本只能设置一个 UnaryInterceptor
+func ChainUnaryServer(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{},
+		info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, h := interceptors[i], next
+			next = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, h)
+			}
+		}
+		return next(ctx, req)
+	}
+}
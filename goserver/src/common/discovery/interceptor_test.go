@@ -0,0 +1,92 @@
+package discovery
+
+import (
+	"common/tlog"
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestInterceptorRoundTrip(t *testing.T) {
+	ctx := tlog.NewContext(context.Background(),
+		tlog.String(tlog.KeyRequestId, "req-1"),
+		tlog.String(tlog.KeyTraceId, "trace-1"),
+		tlog.Int64(tlog.KeyUid, 10086),
+		tlog.Int32(tlog.KeyPlatform, 5),
+	)
+
+	//客户端写入 metadata, 再作为服务端收到的 metadata
+	var md metadata.MD
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+	if err := UnaryClientInterceptor(ctx, "/test/Call", nil, nil, nil, invoker); err != nil {
+		t.Fatal(err)
+	}
+
+	var got context.Context
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		got = ctx
+		return nil, nil
+	}
+	UnaryServerInterceptor(metadata.NewIncomingContext(context.Background(), md), nil, &grpc.UnaryServerInfo{}, handler)
+
+	want := map[string]interface{}{
+		tlog.KeyRequestId: "req-1",
+		tlog.KeyTraceId:   "trace-1",
+		tlog.KeyUid:       int64(10086),
+		tlog.KeyPlatform:  int32(5),
+	}
+	for key, w := range want {
+		if v, ok := tlog.FieldFromContext(got, key); !ok || v != w {
+			t.Errorf("field %s = %v, want %v", key, v, w)
+		}
+	}
+
+	//没有 metadata 时生成 request id, trace id 与其相同
+	UnaryServerInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+	requestId, _ := tlog.FieldFromContext(got, tlog.KeyRequestId)
+	traceId, _ := tlog.FieldFromContext(got, tlog.KeyTraceId)
+	if requestId == "" || requestId != traceId {
+		t.Errorf("unexpected request id %v trace id %v", requestId, traceId)
+	}
+	if _, ok := tlog.FieldFromContext(got, tlog.KeyUid); ok {
+		t.Error("unexpected uid without metadata")
+	}
+}
+
+func TestChainUnaryServer(t *testing.T) {
+	var calls []string
+	interceptor := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			calls = append(calls, name+">")
+			resp, err := handler(ctx, req)
+			calls = append(calls, "<"+name)
+			return resp, err
+		}
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls = append(calls, "handler")
+		return req, nil
+	}
+
+	chain := ChainUnaryServer(interceptor("a"), interceptor("b"))
+	resp, err := chain(context.Background(), "req", &grpc.UnaryServerInfo{}, handler)
+	if err != nil || resp != "req" {
+		t.Fatalf("unexpected resp %v err %v", resp, err)
+	}
+	if s := strings.Join(calls, " "); s != "a> b> handler <b <a" {
+		t.Fatalf("unexpected order %s", s)
+	}
+
+	//再次调用不受上一次的影响
+	calls = nil
+	chain(context.Background(), "req", &grpc.UnaryServerInfo{}, handler)
+	if s := strings.Join(calls, " "); s != "a> b> handler <b <a" {
+		t.Fatalf("unexpected order on second call %s", s)
+	}
+}
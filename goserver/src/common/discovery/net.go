@@ -15,3 +15,8 @@ func getListener() (listener net.Listener, host string, err error) {
 	}
 	return
 }
+
+//Listen 监听本机随机端口, 返回的地址用于 Register
+func Listen() (net.Listener, string, error) {
+	return getListener()
+}
@@ -25,26 +25,35 @@ const (
 )
 
 //Resolver ...
+//name 为服务名时通过 etcd 发现, 也可以是 static://host1:9000,host2:9000 或 dns://name:port
 func Resolver(env, name string, typ DependType) (*grpc.ClientConn, ConsistSplitter) {
 	tlog.Infof("Resolver: env=%s services=%s", env, name)
-	if etcdClient == nil {
-		panic("Subscribe: Please Init Ected Connection Firstly")
-	}
-	if env == "" {
-		panic("Subscribe: Error=env is empty")
-	}
 
-	rn := &ResolverNode{dependType: typ}
-	resolver.Register(resolver.Builder(rn))
+	var rb resolver.Builder
+	var target string
+	if _, _, ok := parseTarget(name); ok {
+		rb = newStaticNode(name, typ)
+		target = rb.Scheme() + ":///" + name
+	} else {
+		if etcdClient == nil {
+			panic("Subscribe: Please Init Ected Connection Firstly")
+		}
+		if env == "" {
+			panic("Subscribe: Error=env is empty")
+		}
+		rb = &ResolverNode{dependType: typ}
+		target = fmt.Sprintf(_ResolverTarget, rb.Scheme(), env, name)
+	}
+	resolver.Register(rb)
 
 	//注册自定义的 balancer
 	b := &balancerDiscovery{name: name}
 	balancer.Register(b)
 
-	target := fmt.Sprintf(_ResolverTarget, rn.Scheme(), env, name)
 	dailOpts := []grpc.DialOption{
 		grpc.WithBalancerName(name),
 		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor),
 		grpc.WithDefaultCallOptions(
 			grpc.MaxCallRecvMsgSize(1024 * 1024 * 16),
 		),
@@ -65,7 +74,9 @@ func (this *ResolverNode) Build(target resolver.Target, cc resolver.ClientConn,
 	this.services = []*Service{}
 	this.resolverConn = cc
 
+	stateLock.Lock()
 	subscribes[target.Endpoint] = this
+	stateLock.Unlock()
 
 	this.subscribe(this.dependType != DependNormal) //初始订阅依赖
 	go this.watching()                              //监控订阅变化
@@ -0,0 +1,92 @@
+package discovery
+
+//本文件实现订阅节点的本地快照. etcd 不可用时, 使用最后一次成功订阅的节点列表,
+//并标记为过期(stale), 待 etcd 恢复后以 etcd 的数据为准重新刷新
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	//快照目录, 为空则不使用快照
+	snapshotDir  = "./discovery_snapshot"
+	snapshotLock sync.Mutex
+)
+
+//snapshot 一个 env/service 的节点快照
+type snapshot struct {
+	Dir      string     `json:"dir"`
+	Services []*Service `json:"services"`
+	Time     int64      `json:"time"`
+}
+
+//SetSnapshotDir 设置节点快照的存储目录, 需在 Resolver 之前调用; dir 为空则关闭快照
+func SetSnapshotDir(dir string) {
+	snapshotLock.Lock()
+	snapshotDir = dir
+	snapshotLock.Unlock()
+}
+
+//snapshotFile /discovery/{env}/{service}/ => {snapshotDir}/discovery_{env}_{service}.json
+func snapshotFile(dir string) string {
+	snapshotLock.Lock()
+	defer snapshotLock.Unlock()
+	if snapshotDir == "" {
+		return ""
+	}
+	name := strings.Replace(strings.Trim(dir, "/"), "/", "_", -1)
+	return filepath.Join(snapshotDir, name+".json")
+}
+
+//snapshotSign 节点列表的签名, 用于判断是否需要重写快照
+func snapshotSign(services []*Service) string {
+	addrs := make([]string, len(services))
+	for i, s := range services {
+		addrs[i] = s.Addr
+	}
+	sort.Strings(addrs)
+	return strings.Join(addrs, ",")
+}
+
+//saveSnapshot 先写临时文件再 rename, 避免进程中断时留下不完整的快照
+func saveSnapshot(dir string, services []*Service) error {
+	file := snapshotFile(dir)
+	if file == "" {
+		return nil
+	}
+	bin, err := json.Marshal(&snapshot{Dir: dir, Services: services, Time: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err = ioutil.WriteFile(tmp, bin, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func loadSnapshot(dir string) (*snapshot, error) {
+	file := snapshotFile(dir)
+	if file == "" {
+		return nil, os.ErrNotExist
+	}
+	bin, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	snap := new(snapshot)
+	if err = json.Unmarshal(bin, snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
@@ -0,0 +1,104 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	etcd "go.etcd.io/etcd/clientv3"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	SetSnapshotDir(dir)
+	defer SetSnapshotDir("./discovery_snapshot")
+
+	if _, err := loadSnapshot("/discovery/test/config/"); !os.IsNotExist(err) {
+		t.Fatalf("load missing snapshot: %v", err)
+	}
+
+	services := []*Service{
+		{Name: "config", Host: "a", Addr: "10.0.0.1:9000"},
+		{Name: "config", Host: "b", Addr: "10.0.0.2:9000"},
+	}
+	if err := saveSnapshot("/discovery/test/config/", services); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := loadSnapshot("/discovery/test/config/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshotSign(snap.Services) != "10.0.0.1:9000,10.0.0.2:9000" {
+		t.Fatalf("unexpected nodes: %+v", snap.Services)
+	}
+}
+
+func TestSubscribeFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	SetSnapshotDir(dir)
+	defer SetSnapshotDir("./discovery_snapshot")
+
+	var getErr error
+	var kvs []*mvccpb.KeyValue
+	get := etcdGet
+	etcdGet = func(ctx context.Context, key string, opts ...etcd.OpOption) (*etcd.GetResponse, error) {
+		if getErr != nil {
+			return nil, getErr
+		}
+		return &etcd.GetResponse{Kvs: kvs}, nil
+	}
+	defer func() { etcdGet = get }()
+
+	key := "/discovery/test/config/"
+	if err := saveSnapshot(key, []*Service{{Name: "config", Addr: "10.0.0.1:9000"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	//etcd 不可用时从快照恢复并标记为过期
+	getErr = fmt.Errorf("etcd unavailable")
+	cc := &testClientConn{}
+	node := &ResolverNode{dir: key, resolverConn: cc}
+	node.subscribe(true)
+	if !node.stale || strings.Join(cc.addrs, ",") != "10.0.0.1:9000" {
+		t.Fatalf("unexpected restore stale=%v addrs=%v", node.stale, cc.addrs)
+	}
+	//已有节点时保持不变, 不再读取快照
+	if r := node.restore(); r != _KeepNodes {
+		t.Fatalf("restore with live nodes = %d", r)
+	}
+
+	//etcd 恢复后以 etcd 的节点替换快照中的节点, 并写入新的快照
+	getErr = nil
+	bin, _ := json.Marshal(&Service{Name: "config", Addr: "10.0.0.2:9000"})
+	kvs = []*mvccpb.KeyValue{{Key: []byte(key + "10.0.0.2:9000"), Value: bin}}
+	node.subscribe(false)
+	if node.stale || strings.Join(cc.addrs, ",") != "10.0.0.2:9000" {
+		t.Fatalf("unexpected reset stale=%v addrs=%v", node.stale, cc.addrs)
+	}
+	snap, err := loadSnapshot(key)
+	if err != nil || snapshotSign(snap.Services) != "10.0.0.2:9000" {
+		t.Fatalf("unexpected snapshot %+v %v", snap, err)
+	}
+
+	//没有快照也没有节点时, 强依赖 panic
+	getErr = fmt.Errorf("etcd unavailable")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect panic without snapshot")
+		}
+	}()
+	(&ResolverNode{dir: "/discovery/test/none/", resolverConn: &testClientConn{}}).subscribe(true)
+}
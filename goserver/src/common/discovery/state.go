@@ -0,0 +1,97 @@
+package discovery
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+//RegisterState 当前进程注册的服务
+type RegisterState struct {
+	Env    string `json:"env"`
+	Name   string `json:"name"`
+	Host   string `json:"host"`
+	Addr   string `json:"addr"`
+	Leased bool   `json:"leased"` //为 false 时正在重新注册
+}
+
+//SubscribeState 当前进程订阅的服务
+type SubscribeState struct {
+	Name  string   `json:"name"`
+	Dir   string   `json:"dir"`
+	Addrs []string `json:"addrs"`
+	Stale bool     `json:"stale"` //节点来自本地快照, 尚未被 etcd 确认
+}
+
+//subscriber 订阅的节点来源, etcd 的 ResolverNode 或 static/dns 的 staticNode
+type subscriber interface {
+	state() SubscribeState
+}
+
+//State 服务发现的运行状态, 用于管理端口查看
+type State struct {
+	Inited     bool             `json:"inited"`
+	Closed     bool             `json:"closed"`
+	Registers  []RegisterState  `json:"registers"`
+	Subscribes []SubscribeState `json:"subscribes"`
+}
+
+//GetState 当前注册与订阅的快照, 按服务名排序
+func GetState() State {
+	st := State{
+		Inited:     etcdClient != nil,
+		Closed:     etcdClientClosed,
+		Registers:  []RegisterState{},
+		Subscribes: []SubscribeState{},
+	}
+
+	stateLock.RLock()
+	for _, s := range registers {
+		st.Registers = append(st.Registers, RegisterState{
+			Env:    s.env,
+			Name:   s.Name,
+			Host:   s.Host,
+			Addr:   s.Addr,
+			Leased: atomic.LoadInt32(&s.leased) == 1,
+		})
+	}
+	nodes := make(map[string]subscriber, len(subscribes))
+	for name, node := range subscribes {
+		nodes[name] = node
+	}
+	stateLock.RUnlock()
+
+	for name, node := range nodes {
+		sub := node.state()
+		sub.Name = name
+		sort.Strings(sub.Addrs)
+		st.Subscribes = append(st.Subscribes, sub)
+	}
+
+	sort.Slice(st.Registers, func(i, j int) bool { return st.Registers[i].Name < st.Registers[j].Name })
+	sort.Slice(st.Subscribes, func(i, j int) bool { return st.Subscribes[i].Name < st.Subscribes[j].Name })
+	return st
+}
+
+func (this *ResolverNode) state() SubscribeState {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	sub := SubscribeState{
+		Dir:   this.dir,
+		Addrs: make([]string, len(this.services)),
+		Stale: this.stale,
+	}
+	for i, s := range this.services {
+		sub.Addrs[i] = s.Addr
+	}
+	return sub
+}
+
+//state static/dns 没有 etcd 目录, Dir 为原始的 target
+func (this *staticNode) state() SubscribeState {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return SubscribeState{
+		Dir:   this.name,
+		Addrs: append([]string{}, this.resolved...),
+	}
+}
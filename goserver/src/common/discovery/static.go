@@ -0,0 +1,183 @@
+package discovery
+
+//本文件实现不依赖 etcd 的两种地址来源, 用于本地开发和第三方 gRPC 服务:
+//  static://host1:9000,host2:9000  固定地址列表
+//  dns://name:port                 定期解析域名得到地址列表
+//两者与 etcd 发现共用 balancerDiscovery, 一致性 hash 与 target 路由保持可用
+
+import (
+	"common/tlog"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	SchemeStatic = "static"
+	SchemeDNS    = "dns"
+
+	//注册到 gRPC 的 scheme, 避免覆盖 gRPC 内置的 dns resolver
+	_StaticResolverSchema = "discovery-static"
+	_DNSResolverSchema    = "discovery-dns"
+)
+
+//lookupHost 解析域名, 测试中可替换
+var lookupHost = net.LookupHost
+
+//staticNode 固定地址或 DNS 解析得到的节点列表
+type staticNode struct {
+	dependType DependType
+	scheme     string
+	name       string //原始的 target, eg. dns://name:port
+
+	addrs []string //static 模式下的固定地址
+	host  string   //dns 模式下的域名
+	port  string   //dns 模式下的端口
+
+	lock         sync.Mutex
+	resolved     []string
+	resolverConn resolver.ClientConn
+	done         chan struct{}
+}
+
+//parseTarget 识别 {scheme}://{endpoint} 形式的服务名, 不是则表示走 etcd 发现
+func parseTarget(name string) (scheme, endpoint string, ok bool) {
+	parts := strings.SplitN(name, "://", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], strings.TrimLeft(parts[1], "/"), true
+}
+
+func newStaticNode(name string, typ DependType) *staticNode {
+	scheme, endpoint, _ := parseTarget(name)
+	node := &staticNode{
+		dependType: typ,
+		scheme:     scheme,
+		name:       name,
+		done:       make(chan struct{}),
+	}
+	switch scheme {
+	case SchemeStatic:
+		for _, addr := range strings.Split(endpoint, ",") {
+			addr = strings.TrimSpace(addr)
+			if addr == "" {
+				continue
+			}
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				panic(fmt.Sprintf("Resolver: Error='%s' Target=%s", err.Error(), name))
+			}
+			node.addrs = append(node.addrs, addr)
+		}
+		if len(node.addrs) == 0 {
+			panic("Resolver: Error=Static Addrs Was Empty, Target=" + name)
+		}
+	case SchemeDNS:
+		host, port, err := net.SplitHostPort(endpoint)
+		if err != nil || host == "" || port == "" {
+			panic("Resolver: Error=Addr Format Invalid: dns://{name}:{port}, Target=" + name)
+		}
+		node.host, node.port = host, port
+	default:
+		panic("Resolver: Error=Unknown Scheme, Target=" + name)
+	}
+	return node
+}
+
+//Builder.Build ...
+func (this *staticNode) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	this.resolverConn = cc
+
+	stateLock.Lock()
+	subscribes[this.name] = this
+	stateLock.Unlock()
+
+	if this.scheme == SchemeStatic {
+		this.update(this.addrs)
+		return this, nil
+	}
+
+	this.lookup(this.dependType != DependNormal) //初始解析
+	go this.watching()                           //定期重新解析
+	return this, nil
+}
+
+//Builder.Scheme ...
+func (this *staticNode) Scheme() string {
+	if this.scheme == SchemeDNS {
+		return _DNSResolverSchema
+	}
+	return _StaticResolverSchema
+}
+
+//Resolver.ResolveNow ...
+func (this *staticNode) ResolveNow(rn resolver.ResolveNowOptions) {
+	//ignore
+}
+
+//Resolver.Close ...
+func (this *staticNode) Close() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	select {
+	case <-this.done:
+	default:
+		close(this.done)
+	}
+}
+
+func (this *staticNode) lookup(immediately bool) {
+	ips, err := lookupHost(this.host)
+	if err == nil && len(ips) == 0 {
+		err = fmt.Errorf("no such host")
+	}
+	if err != nil {
+		if immediately {
+			panic(fmt.Sprintf("Resolver: Error='%s' Target=%s", err.Error(), this.name))
+		}
+		tlog.Errorf("Resolver: Error='%s' Target=%s", err.Error(), this.name)
+		return
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip, this.port)
+	}
+	this.update(addrs)
+}
+
+func (this *staticNode) watching() {
+	tlog.Infof("Watching %s", this.name)
+	tick := time.NewTicker(_DirectoryInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-this.done:
+			return
+		case <-tick.C:
+			this.lookup(false)
+		}
+	}
+}
+
+//update 地址有变化时通知 gRPC
+func (this *staticNode) update(addrs []string) {
+	sort.Strings(addrs)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if strings.Join(addrs, ",") == strings.Join(this.resolved, ",") {
+		return
+	}
+	this.resolved = addrs
+	tlog.Infof("Resolver: Target=%s Nodes=%v", this.name, addrs)
+
+	state := resolver.State{Addresses: make([]resolver.Address, len(addrs))}
+	for i, addr := range addrs {
+		state.Addresses[i] = resolver.Address{Addr: addr, ServerName: this.host}
+	}
+	this.resolverConn.UpdateState(state)
+}
@@ -0,0 +1,129 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/resolver"
+)
+
+//testClientConn 记录 resolver 推送的地址
+type testClientConn struct {
+	resolver.ClientConn
+	addrs []string
+}
+
+func (this *testClientConn) UpdateState(s resolver.State) {
+	this.addrs = this.addrs[:0]
+	for _, a := range s.Addresses {
+		this.addrs = append(this.addrs, a.Addr)
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		scheme   string
+		endpoint string
+		ok       bool
+	}{
+		{"config", "", "", false},
+		{"static://10.0.0.1:9000,10.0.0.2:9000", "static", "10.0.0.1:9000,10.0.0.2:9000", true},
+		{"dns://config.local:9000", "dns", "config.local:9000", true},
+		{"dns:///config.local:9000", "dns", "config.local:9000", true},
+	}
+	for _, tt := range tests {
+		scheme, endpoint, ok := parseTarget(tt.name)
+		if scheme != tt.scheme || endpoint != tt.endpoint || ok != tt.ok {
+			t.Errorf("parseTarget(%q) = %q, %q, %v", tt.name, scheme, endpoint, ok)
+		}
+	}
+}
+
+func TestNewStaticNode(t *testing.T) {
+	tests := []struct {
+		name  string
+		addrs []string
+		host  string
+		port  string
+		panic bool
+	}{
+		{name: "static://10.0.0.1:9000, 10.0.0.2:9000,", addrs: []string{"10.0.0.1:9000", "10.0.0.2:9000"}},
+		{name: "static://", panic: true},
+		{name: "static://10.0.0.1", panic: true},
+		{name: "dns://config.local:9000", host: "config.local", port: "9000"},
+		{name: "dns://config.local", panic: true},
+		{name: "dns://:9000", panic: true},
+		{name: "http://config.local:9000", panic: true},
+	}
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if r := recover(); (r != nil) != tt.panic {
+					t.Errorf("newStaticNode(%q) panic=%v", tt.name, r)
+				}
+			}()
+			node := newStaticNode(tt.name, DependNormal)
+			if strings.Join(node.addrs, ",") != strings.Join(tt.addrs, ",") || node.host != tt.host || node.port != tt.port {
+				t.Errorf("newStaticNode(%q) = %v %q %q", tt.name, node.addrs, node.host, node.port)
+			}
+		}()
+	}
+}
+
+func TestDNSRefresh(t *testing.T) {
+	var ips []string
+	var lookupErr error
+	lookupHost = func(host string) ([]string, error) {
+		return ips, lookupErr
+	}
+	defer func() { lookupHost = net.LookupHost }()
+
+	name := "dns://config.local:9000"
+	node := newStaticNode(name, DependNormal)
+	cc := &testClientConn{}
+	ips = []string{"10.0.0.2", "10.0.0.1"}
+	node.Build(resolver.Target{}, cc, resolver.BuildOptions{})
+	defer node.Close()
+	if strings.Join(cc.addrs, ",") != "10.0.0.1:9000,10.0.0.2:9000" {
+		t.Fatalf("unexpected addrs %v", cc.addrs)
+	}
+
+	//解析失败时保留之前的地址
+	lookupErr = fmt.Errorf("timeout")
+	node.lookup(false)
+	lookupErr = nil
+	if strings.Join(cc.addrs, ",") != "10.0.0.1:9000,10.0.0.2:9000" {
+		t.Fatalf("unexpected addrs after failure %v", cc.addrs)
+	}
+
+	ips = []string{"10.0.0.3"}
+	node.lookup(false)
+	if strings.Join(cc.addrs, ",") != "10.0.0.3:9000" {
+		t.Fatalf("unexpected addrs after refresh %v", cc.addrs)
+	}
+
+	var found bool
+	for _, sub := range GetState().Subscribes {
+		if sub.Name == name {
+			found = true
+			if sub.Dir != name || strings.Join(sub.Addrs, ",") != "10.0.0.3:9000" {
+				t.Fatalf("unexpected state %+v", sub)
+			}
+		}
+	}
+	if !found {
+		t.Fatal("dns subscription missing from state")
+	}
+
+	//解析失败且为强依赖时 panic
+	lookupErr = fmt.Errorf("no such host")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect panic for DependMust")
+		}
+	}()
+	newStaticNode(name, DependMust).Build(resolver.Target{}, &testClientConn{}, resolver.BuildOptions{})
+}
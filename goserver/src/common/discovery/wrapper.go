@@ -15,7 +15,7 @@ func RegisterConfigServer(env string, implementation config.ConfigServer) error
 		return err
 	}
 
-	srv := grpc.NewServer()
+	srv := NewGrpcServer()
 	config.RegisterConfigServer(srv, implementation)
 	go srv.Serve(listener)
 	Register(env, &Service{Name: ConfigServer, Addr: addr})
@@ -26,3 +26,9 @@ func ResolverConfigServer(env string) config.ConfigClient {
 	conn, _ := Resolver(env, ConfigServer, DependNormal)
 	return config.NewConfigClient(conn)
 }
+
+//NewGrpcServer 带有服务端拦截器的 grpc.Server, interceptors 在 UnaryServerInterceptor 之后依次执行
+func NewGrpcServer(interceptors ...grpc.UnaryServerInterceptor) *grpc.Server {
+	chain := append([]grpc.UnaryServerInterceptor{UnaryServerInterceptor}, interceptors...)
+	return grpc.NewServer(grpc.UnaryInterceptor(ChainUnaryServer(chain...)))
+}
@@ -0,0 +1,310 @@
+package feature
+
+//功能开关: 开关定义存放在配置中心的 features 项, 本地缓存快照并计算, 不产生网络请求
+//
+//	[flags.new_home]
+//	enabled = true
+//	default = "off"
+//	[[flags.new_home.rules]]
+//	uids = ["10000-19999", "123"]       # uid 或闭区间
+//	variant = "on"
+//	[[flags.new_home.rules]]
+//	platforms = [5, 6]                  # defs.PlatformAndroid / defs.PlatformIos
+//	percent = 20                        # 按 uid 哈希取 20% 的用户
+//	variant = "on"
+//
+//	[flags.home_layout]                 # 多值开关
+//	enabled = true
+//	default = "a"
+//	[[flags.home_layout.rules]]
+//	split = { a = 50, b = 30, c = 20 }  # 按 uid 哈希分桶, 合计不足 100 时剩余的用户不命中该规则
+//
+//目前只支持 etcd 配置中心, 尚未支持经由 config_server 从 MySQL 读取
+
+import (
+	"common/confcenter"
+	"common/metrics"
+	"common/tlog"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//Key 开关在配置中心中的配置项
+const Key = "features"
+
+//布尔开关的取值
+const (
+	On  = "on"
+	Off = "off"
+)
+
+//_Buckets 按 uid 哈希的桶数
+const _Buckets = 10000
+
+//Config Key 的内容
+type Config struct {
+	Flags map[string]*Flag `toml:"flags"`
+}
+
+//Flag 一个开关, 按顺序匹配规则, 第一条命中的规则决定取值, 都不命中时取 Default
+type Flag struct {
+	Enabled bool   `toml:"enabled"` //为 false 时总是取 Default
+	Default string `toml:"default"` //为空时布尔开关视为 off
+	Rules   []Rule `toml:"rules"`
+}
+
+//Rule 所有条件同时满足时命中, 未设置的条件不限制
+type Rule struct {
+	Uids      []string       `toml:"uids"`      //uid 或闭区间, eg. "10000-19999"
+	Platforms []int32        `toml:"platforms"` //project.GetPlatformId 的返回值
+	Percent   int            `toml:"percent"`   //按 uid 哈希取部分用户(0-100), 0 表示不限
+	Variant   string         `toml:"variant"`   //命中时的取值
+	Split     map[string]int `toml:"split"`     //多值分桶的百分比, 设置后忽略 Variant 与 Percent
+}
+
+//User 计算开关时使用的用户信息, uid 为 0 时不匹配 uid 与百分比条件
+type User struct {
+	Uid      int64
+	Platform int32
+}
+
+type uidRange struct {
+	from, to int64
+}
+
+type bucket struct {
+	variant string
+	upper   int //累计上界, 不含
+}
+
+type rule struct {
+	uids      []uidRange
+	platforms map[int32]bool
+	percent   int
+	variant   string
+	split     []bucket
+}
+
+type flag struct {
+	name    string
+	enabled bool
+	def     string
+	rules   []*rule
+}
+
+//snapshot map[string]*flag, 整体替换
+var snapshot atomic.Value
+
+var evaluations = metrics.NewCounter("feature_evaluations", "flag", "variant")
+
+func init() {
+	snapshot.Store(map[string]*flag{})
+}
+
+//Init 从配置中心读取开关并监听变化, 格式错误的修改会被忽略, 保留之前的快照
+func Init(c *confcenter.Center) error {
+	//初始快照加载完成之前, Bind 之后到达的修改等待, 避免被较旧的初始值覆盖
+	var lock sync.Mutex
+	lock.Lock()
+	defer lock.Unlock()
+	var conf Config
+	if err := c.Bind(Key, &conf, func(v interface{}) {
+		lock.Lock()
+		defer lock.Unlock()
+		if err := Load(v.(*Config)); err != nil {
+			tlog.Errorf("Feature: Load Error=%s", err.Error())
+		}
+	}); err != nil {
+		return err
+	}
+	return Load(&conf)
+}
+
+//Load 替换快照, 也可用于在测试中设置开关
+func Load(c *Config) error {
+	flags := make(map[string]*flag, len(c.Flags))
+	for name, f := range c.Flags {
+		cf, err := compile(name, f)
+		if err != nil {
+			return err
+		}
+		flags[name] = cf
+	}
+	snapshot.Store(flags)
+	tlog.Infof("Feature: Loaded Flags=%d", len(flags))
+	return nil
+}
+
+func compile(name string, f *Flag) (*flag, error) {
+	cf := &flag{name: name, enabled: f.Enabled, def: f.Default}
+	for i, r := range f.Rules {
+		cr := &rule{variant: r.Variant}
+		for _, s := range r.Uids {
+			ur, err := parseUidRange(s)
+			if err != nil {
+				return nil, fmt.Errorf("flag %s rule %d: %s", name, i, err.Error())
+			}
+			cr.uids = append(cr.uids, ur)
+		}
+		if len(r.Platforms) > 0 {
+			cr.platforms = make(map[int32]bool, len(r.Platforms))
+			for _, p := range r.Platforms {
+				cr.platforms[p] = true
+			}
+		}
+		if r.Percent < 0 || r.Percent > 100 {
+			return nil, fmt.Errorf("flag %s rule %d: percent %d out of range [0, 100]", name, i, r.Percent)
+		}
+		cr.percent = r.Percent * _Buckets / 100
+		if len(r.Split) > 0 {
+			variants := make([]string, 0, len(r.Split))
+			for v := range r.Split {
+				variants = append(variants, v)
+			}
+			sort.Strings(variants)
+			upper := 0
+			for _, v := range variants {
+				if r.Split[v] < 0 {
+					return nil, fmt.Errorf("flag %s rule %d: negative split %s", name, i, v)
+				}
+				upper += r.Split[v] * _Buckets / 100
+				cr.split = append(cr.split, bucket{variant: v, upper: upper})
+			}
+			if upper > _Buckets {
+				return nil, fmt.Errorf("flag %s rule %d: split sums over 100", name, i)
+			}
+		} else if r.Variant == "" {
+			return nil, fmt.Errorf("flag %s rule %d: variant or split is required", name, i)
+		}
+		cf.rules = append(cf.rules, cr)
+	}
+	return cf, nil
+}
+
+func parseUidRange(s string) (uidRange, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), "-", 2)
+	from, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+	if err != nil {
+		return uidRange{}, fmt.Errorf("invalid uid range %q", s)
+	}
+	to := from
+	if len(parts) == 2 {
+		if to, err = strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64); err != nil || to < from {
+			return uidRange{}, fmt.Errorf("invalid uid range %q", s)
+		}
+	}
+	return uidRange{from: from, to: to}, nil
+}
+
+//hash 同一开关下同一用户的桶号固定, 不同开关之间相互独立
+func hash(name string, uid int64) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{':'})
+	h.Write([]byte(strconv.FormatInt(uid, 10)))
+	return int(h.Sum32() % _Buckets)
+}
+
+func (this *rule) match(name string, u User) (string, bool) {
+	if len(this.uids) > 0 {
+		hit := false
+		for _, r := range this.uids {
+			if u.Uid >= r.from && u.Uid <= r.to {
+				hit = true
+				break
+			}
+		}
+		if !hit {
+			return "", false
+		}
+	}
+	if this.platforms != nil && !this.platforms[u.Platform] {
+		return "", false
+	}
+	if len(this.split) > 0 {
+		if u.Uid == 0 {
+			return "", false
+		}
+		b := hash(name, u.Uid)
+		for _, s := range this.split {
+			if b < s.upper {
+				return s.variant, true
+			}
+		}
+		return "", false
+	}
+	if this.percent > 0 && this.percent < _Buckets {
+		if u.Uid == 0 || hash(name, u.Uid) >= this.percent {
+			return "", false
+		}
+	}
+	return this.variant, true
+}
+
+func (this *flag) eval(u User) string {
+	if this.enabled {
+		for _, r := range this.rules {
+			if v, ok := r.match(this.name, u); ok {
+				return v
+			}
+		}
+	}
+	return this.def
+}
+
+//VariantFor 开关对用户的取值, 开关不存在时返回空字符串
+func VariantFor(u User, name string) string {
+	f, ok := snapshot.Load().(map[string]*flag)[name]
+	if !ok {
+		return ""
+	}
+	v := f.eval(u)
+	evaluations.With(name, v).Inc()
+	return v
+}
+
+//EnabledFor 布尔开关, 取值为 on 或 true 时返回 true
+func EnabledFor(u User, name string) bool {
+	v := VariantFor(u, name)
+	return v == On || v == "true"
+}
+
+//UserFromContext 从 context 的日志字段中取 uid 与平台,
+//gin 中由 project.LogContext 与 project.SetLogUid 写入, gRPC 服务中由 discovery.UnaryServerInterceptor 恢复
+func UserFromContext(ctx context.Context) User {
+	var u User
+	if v, ok := tlog.FieldFromContext(ctx, tlog.KeyUid); ok {
+		u.Uid, _ = v.(int64)
+	}
+	if v, ok := tlog.FieldFromContext(ctx, tlog.KeyPlatform); ok {
+		u.Platform, _ = v.(int32)
+	}
+	return u
+}
+
+//Variant 按 context 中的用户计算开关取值
+func Variant(ctx context.Context, name string) string {
+	return VariantFor(UserFromContext(ctx), name)
+}
+
+//Enabled 按 context 中的用户计算布尔开关
+func Enabled(ctx context.Context, name string) bool {
+	return EnabledFor(UserFromContext(ctx), name)
+}
+
+//Flags 当前快照中的开关名
+func Flags() []string {
+	flags := snapshot.Load().(map[string]*flag)
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
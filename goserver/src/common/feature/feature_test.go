@@ -0,0 +1,154 @@
+package feature
+
+import (
+	"common/defs"
+	"common/tlog"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testFlags = `
+[flags.new_home]
+enabled = true
+default = "off"
+[[flags.new_home.rules]]
+uids = ["10000-19999", "123"]
+variant = "on"
+[[flags.new_home.rules]]
+platforms = [5]
+percent = 20
+variant = "on"
+
+[flags.layout]
+enabled = true
+default = "a"
+[[flags.layout.rules]]
+split = { a = 50, b = 30, c = 20 }
+
+[flags.disabled]
+enabled = false
+default = "off"
+[[flags.disabled.rules]]
+variant = "on"
+`
+
+func load(t *testing.T, s string) {
+	var c Config
+	if _, err := toml.Decode(s, &c); err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(&c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEval(t *testing.T) {
+	load(t, testFlags)
+	defer Load(&Config{})
+
+	cases := []struct {
+		u    User
+		name string
+		want bool
+	}{
+		{User{Uid: 123}, "new_home", true},
+		{User{Uid: 15000, Platform: defs.PlatformIos}, "new_home", true},
+		{User{Uid: 20000, Platform: defs.PlatformIos}, "new_home", false},
+		{User{Uid: 0, Platform: defs.PlatformAndroid}, "new_home", false},
+		{User{Uid: 1}, "disabled", false},
+		{User{Uid: 1}, "missing", false},
+	}
+	for _, c := range cases {
+		if got := EnabledFor(c.u, c.name); got != c.want {
+			t.Errorf("%s %+v: got %v, want %v", c.name, c.u, got, c.want)
+		}
+	}
+
+	//百分比与分桶按 uid 哈希, 结果稳定且比例接近配置
+	on := 0
+	variants := map[string]int{}
+	for uid := int64(100000); uid < 110000; uid++ {
+		u := User{Uid: uid, Platform: defs.PlatformAndroid}
+		if EnabledFor(u, "new_home") {
+			on++
+		}
+		if EnabledFor(u, "new_home") != EnabledFor(u, "new_home") {
+			t.Fatalf("unstable result for uid %d", uid)
+		}
+		variants[VariantFor(u, "layout")]++
+	}
+	if on < 1800 || on > 2200 {
+		t.Errorf("percent 20: got %d of 10000", on)
+	}
+	for v, want := range map[string]int{"a": 5000, "b": 3000, "c": 2000} {
+		if n := variants[v]; n < want-300 || n > want+300 {
+			t.Errorf("split %s: got %d, want about %d", v, n, want)
+		}
+	}
+	if VariantFor(User{}, "layout") != "a" {
+		t.Error("user without uid should get default variant")
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for _, s := range []string{
+		"[flags.x]\n[[flags.x.rules]]\nuids = [\"20-10\"]\nvariant = \"on\"",
+		"[flags.x]\n[[flags.x.rules]]\npercent = 120\nvariant = \"on\"",
+		"[flags.x]\n[[flags.x.rules]]\nsplit = { a = 60, b = 60 }",
+		"[flags.x]\n[[flags.x.rules]]\npercent = 10",
+	} {
+		var c Config
+		toml.Decode(s, &c)
+		if err := Load(&c); err == nil {
+			t.Errorf("expect error for %q", s)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	load(t, testFlags)
+	defer Load(&Config{})
+
+	gin.SetMode(gin.ReleaseMode)
+	e := gin.New()
+	e.Use(func(c *gin.Context) {
+		ctx := tlog.NewContext(c.Request.Context(),
+			tlog.Int64(tlog.KeyUid, 123), tlog.Int32(tlog.KeyPlatform, defs.PlatformIos))
+		c.Request = c.Request.WithContext(ctx)
+	})
+	e.GET("/new", Require("new_home"), func(c *gin.Context) { c.String(http.StatusOK, GinVariant(c, "layout")) })
+	e.GET("/off", Require("disabled"), func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	//GinFor 使用给出的 uid, 不读取 context 中的 uid
+	e.GET("/uid", func(c *gin.Context) {
+		if GinFor(c, 20000, "new_home") {
+			c.String(http.StatusOK, "ok")
+			return
+		}
+		c.AbortWithStatus(http.StatusForbidden)
+	})
+	for path, code := range map[string]int{"/new": http.StatusOK, "/off": http.StatusNotFound, "/uid": http.StatusForbidden} {
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != code {
+			t.Errorf("%s: got %d, want %d", path, w.Code, code)
+		}
+	}
+
+	interceptor := UnaryServerInterceptor(map[string]string{"/config.Config/Info": "disabled"})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	ctx := tlog.NewContext(context.Background(), tlog.Int64(tlog.KeyUid, 123))
+	if _, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/config.Config/Info"}, handler); status.Code(err) != codes.Unimplemented {
+		t.Errorf("disabled method: %v", err)
+	}
+	if resp, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/config.Config/Ping"}, handler); err != nil || resp != "ok" {
+		t.Errorf("other method: %v %v", resp, err)
+	}
+}
@@ -0,0 +1,56 @@
+package feature
+
+import (
+	"common/project"
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//Gin gin handler 中按请求的用户计算开关, 需要在 project.LogContext 之后使用,
+//uid 来自登录校验中调用的 project.SetLogUid, 未调用时 uid 为 0, 按 uid 与百分比的规则不会命中
+func Gin(c *gin.Context, name string) bool {
+	return Enabled(c.Request.Context(), name)
+}
+
+//GinVariant gin handler 中按请求的用户计算多值开关
+func GinVariant(c *gin.Context, name string) string {
+	return Variant(c.Request.Context(), name)
+}
+
+//GinFor 使用调用方给出的 uid 计算开关, 平台取自请求头, 用于未调用 project.SetLogUid 的接口
+func GinFor(c *gin.Context, uid int64, name string) bool {
+	return EnabledFor(User{Uid: uid, Platform: project.GetPlatformId(c)}, name)
+}
+
+//GinVariantFor 使用调用方给出的 uid 计算多值开关
+func GinVariantFor(c *gin.Context, uid int64, name string) string {
+	return VariantFor(User{Uid: uid, Platform: project.GetPlatformId(c)}, name)
+}
+
+//Require gin 中间件, 开关关闭时返回 404, 用于灰度中的接口
+func Require(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !Gin(c, name) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.Next()
+	}
+}
+
+//UnaryServerInterceptor methods 为 gRPC 完整方法名(eg. /config.Config/Info)到开关名的映射,
+//开关关闭时返回 Unimplemented, 需要在 discovery.UnaryServerInterceptor 之后执行以取得用户信息
+func UnaryServerInterceptor(methods map[string]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{},
+		info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if name, ok := methods[info.FullMethod]; ok && !Enabled(ctx, name) {
+			return nil, status.Errorf(codes.Unimplemented, "method %s is disabled by feature %s", info.FullMethod, name)
+		}
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,51 @@
+package fileutil
+
+//日志与数据文件的切分归档共用的文件操作, 不依赖 common 中的其他包, tlog 也可以使用
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+)
+
+//Gzip 压缩为 {name}.gz 后删除原文件, 保留原文件的修改时间以便按时间清理
+func Gzip(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := name + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	os.Chtimes(tmp, fi.ModTime(), fi.ModTime())
+	if err = os.Rename(tmp, name+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+//Exists 文件或目录是否存在
+func Exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
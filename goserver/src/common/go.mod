@@ -6,8 +6,10 @@ require (
 	github.com/BurntSushi/toml v0.3.1
 	github.com/apache/rocketmq-client-go/v2 v2.1.0-rc5
 	github.com/aws/aws-sdk-go v1.35.7
-	github.com/coreos/etcd v3.3.13+incompatible // indirect
+	github.com/coreos/etcd v3.3.13+incompatible
+	github.com/facebookgo/grace v0.0.0-20180706040059-75cf19382434
 	github.com/gin-gonic/gin v1.6.3
+	github.com/go-playground/validator/v10 v10.2.0
 	github.com/go-redis/redis v6.15.9+incompatible
 	github.com/go-sql-driver/mysql v1.5.0
 	github.com/gogo/protobuf v1.3.1 // indirect
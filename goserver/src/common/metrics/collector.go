@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"common/tlog"
+	"database/sql"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+//_CollectInterval 采样周期, 小于统计周期以便 Prometheus 抓取到较新的值
+const _CollectInterval = 10 * time.Second
+
+//Collector 定期采样的数据源, 在 Collect 中设置 gauge
+type Collector interface {
+	Collect()
+}
+
+type CollectorFunc func()
+
+func (f CollectorFunc) Collect() {
+	f()
+}
+
+var collectorLock sync.Mutex
+var collectors = map[string]Collector{}
+var collectOnce sync.Once
+
+//RegisterCollector 注册数据源并立即采样一次, 同名的会被替换
+func RegisterCollector(name string, c Collector) {
+	collectorLock.Lock()
+	collectors[name] = c
+	collectorLock.Unlock()
+	c.Collect()
+	collectOnce.Do(func() {
+		go collectLoop()
+	})
+}
+
+func collect() {
+	collectorLock.Lock()
+	names := make([]string, 0, len(collectors))
+	for name := range collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cs := make([]Collector, len(names))
+	for i, name := range names {
+		cs[i] = collectors[name]
+	}
+	collectorLock.Unlock()
+
+	for _, c := range cs {
+		c.Collect()
+	}
+}
+
+func collectLoop() {
+	for range time.NewTicker(_CollectInterval).C {
+		collect()
+	}
+}
+
+var (
+	goroutines = NewGauge("go_goroutines")
+	goMemory   = NewGauge("go_memory_bytes", "stat")
+	gcCount    = NewGauge("go_gc_count")
+	gcPause    = NewGauge("go_gc_pause_seconds", "stat")
+	tlogQueue  = NewGauge("tlog_queue")
+	sqlPool    = NewGauge("sql_pool", "db", "stat")
+	redisPool  = NewGauge("redis_pool", "redis", "stat")
+	cacheItems = NewGauge("process_cache_items", "cache")
+)
+
+//RuntimeCollector goroutine 数, 内存, GC 次数与两次采样间的最大停顿
+func RuntimeCollector() Collector {
+	var lastGC uint32
+	return CollectorFunc(func() {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		goroutines.With().Set(float64(runtime.NumGoroutine()))
+		goMemory.With("heap_alloc").Set(float64(ms.HeapAlloc))
+		goMemory.With("heap_inuse").Set(float64(ms.HeapInuse))
+		goMemory.With("sys").Set(float64(ms.Sys))
+		gcCount.With().Set(float64(ms.NumGC))
+
+		//PauseNs 是最近 256 次 GC 的环形缓冲
+		var max uint64
+		n := ms.NumGC - lastGC
+		if n > 256 {
+			n = 256
+		}
+		for i := uint32(0); i < n; i++ {
+			if p := ms.PauseNs[(ms.NumGC-i+255)%256]; p > max {
+				max = p
+			}
+		}
+		lastGC = ms.NumGC
+		gcPause.With("max").Set(float64(max) / 1e9)
+		gcPause.With("total").Set(float64(ms.PauseTotalNs) / 1e9)
+	})
+}
+
+//TlogCollector tlog 队列中等待写入的日志数
+func TlogCollector() Collector {
+	return CollectorFunc(func() {
+		tlogQueue.With().Set(float64(tlog.Stats().Queue))
+	})
+}
+
+//DBCollector util.NewMysql 返回的连接池状态
+func DBCollector(name string, db *sql.DB) Collector {
+	return CollectorFunc(func() {
+		s := db.Stats()
+		sqlPool.With(name, "max_open").Set(float64(s.MaxOpenConnections))
+		sqlPool.With(name, "open").Set(float64(s.OpenConnections))
+		sqlPool.With(name, "in_use").Set(float64(s.InUse))
+		sqlPool.With(name, "idle").Set(float64(s.Idle))
+		sqlPool.With(name, "wait_count").Set(float64(s.WaitCount))
+		sqlPool.With(name, "wait_seconds").Set(s.WaitDuration.Seconds())
+		sqlPool.With(name, "max_idle_closed").Set(float64(s.MaxIdleClosed))
+		sqlPool.With(name, "max_lifetime_closed").Set(float64(s.MaxLifetimeClosed))
+	})
+}
+
+//RedisCollector util.NewRedisClient 返回的连接池状态
+func RedisCollector(name string, client *redis.Client) Collector {
+	return CollectorFunc(func() {
+		s := client.PoolStats()
+		redisPool.With(name, "hits").Set(float64(s.Hits))
+		redisPool.With(name, "misses").Set(float64(s.Misses))
+		redisPool.With(name, "timeouts").Set(float64(s.Timeouts))
+		redisPool.With(name, "total").Set(float64(s.TotalConns))
+		redisPool.With(name, "idle").Set(float64(s.IdleConns))
+		redisPool.With(name, "stale").Set(float64(s.StaleConns))
+	})
+}
+
+//Sizer processcache.ProcessCache 与 ListCache 都实现了 Len
+type Sizer interface {
+	Len() int64
+}
+
+//CacheCollector 进程内缓存的条目数
+func CacheCollector(name string, c Sizer) Collector {
+	return CollectorFunc(func() {
+		cacheItems.With(name).Set(float64(c.Len()))
+	})
+}
@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	_FalconUrl     = "http://localhost:1988/v1/push"
+	_BatchSize     = 500
+	_Retries       = 3
+	_RetryInterval = 500 * time.Millisecond
+)
+
+//FalconExporter 推送到 Open-Falcon agent 或兼容的 push 接口, 分批发送, 失败按指数退避重试
+type FalconExporter struct {
+	url       string
+	batchSize int
+	retries   int
+	client    *http.Client
+}
+
+//NewFalconExporter batchSize 为 0 或 retries 为负数时使用默认值, retries 为 0 时不重试
+func NewFalconExporter(url string, batchSize int, retries int) *FalconExporter {
+	if batchSize <= 0 {
+		batchSize = _BatchSize
+	}
+	if retries < 0 {
+		retries = _Retries
+	}
+	return &FalconExporter{
+		url:       url,
+		batchSize: batchSize,
+		retries:   retries,
+		client: &http.Client{
+			Timeout: 3 * time.Second,
+			Transport: &http.Transport{
+				MaxIdleConns:          2,
+				IdleConnTimeout:       120 * time.Second,
+				DisableCompression:    true,
+				ResponseHeaderTimeout: 3 * time.Second,
+			},
+		},
+	}
+}
+
+func (this *FalconExporter) Export(ds []*Data) error {
+	var errs []string
+	for i := 0; i < len(ds); i += this.batchSize {
+		end := i + this.batchSize
+		if end > len(ds) {
+			end = len(ds)
+		}
+		if err := this.pushRetry(ds[i:end]); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("falcon push %s: %s", this.url, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (this *FalconExporter) pushRetry(ds []*Data) error {
+	bb, _ := json.Marshal(ds)
+	interval := _RetryInterval
+	var err error
+	for i := 0; ; i++ {
+		if err = this.push(bb); err == nil || i >= this.retries {
+			return err
+		}
+		time.Sleep(interval)
+		interval *= 2
+	}
+}
+
+func (this *FalconExporter) push(bb []byte) error {
+	req, _ := http.NewRequest("POST", this.url, bytes.NewBuffer(bb))
+	req.Header.Add("Content-Type", "application/json")
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+//MemoryExporter 保存每次推送的数据, 用于单元测试中检查指标
+type MemoryExporter struct {
+	lock sync.Mutex
+	ds   []*Data
+}
+
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{}
+}
+
+func (this *MemoryExporter) Export(ds []*Data) error {
+	this.lock.Lock()
+	this.ds = append(this.ds, ds...)
+	this.lock.Unlock()
+	return nil
+}
+
+//Data 目前收到的所有数据
+func (this *MemoryExporter) Data() []*Data {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append([]*Data(nil), this.ds...)
+}
+
+//Find 最近一次推送中 metric 与 tags 相同的数据
+func (this *MemoryExporter) Find(metric string, tags string) (*Data, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for i := len(this.ds) - 1; i >= 0; i-- {
+		if d := this.ds[i]; d.Metric == metric && d.Tags == tags {
+			return d, true
+		}
+	}
+	return nil, false
+}
+
+func (this *MemoryExporter) Reset() {
+	this.lock.Lock()
+	this.ds = nil
+	this.lock.Unlock()
+}
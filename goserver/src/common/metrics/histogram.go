@@ -0,0 +1,79 @@
+package metrics
+
+import "math/bits"
+
+//histogram 对数线性分桶的耗时直方图(微秒), 与 HDR 类似:
+//小于 64 的值精确记录, 之后每个 2 的幂区间分 32 个桶, 相对误差不超过 1/32
+const (
+	_SubBits    = 5
+	_SubBuckets = 1 << _SubBits
+	_MaxShift   = 36 //超过 2^42 微秒(约 50 天)的值按最大桶计
+	_HistSize   = 2*_SubBuckets + _MaxShift*_SubBuckets
+)
+
+type histogram struct {
+	counts []uint32
+	count  uint64
+	max    int64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint32, _HistSize)}
+}
+
+func histIndex(v int64) int {
+	if v < 0 {
+		v = 0
+	}
+	if v < 2*_SubBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - _SubBits - 1
+	if shift > _MaxShift {
+		return _HistSize - 1
+	}
+	mant := int(v >> uint(shift))
+	return 2*_SubBuckets + (shift-1)*_SubBuckets + mant - _SubBuckets
+}
+
+//histValue 桶的中间值
+func histValue(i int) int64 {
+	if i < 2*_SubBuckets {
+		return int64(i)
+	}
+	shift := uint((i-2*_SubBuckets)/_SubBuckets + 1)
+	mant := int64((i-2*_SubBuckets)%_SubBuckets + _SubBuckets)
+	lower := mant << shift
+	upper := (mant+1)<<shift - 1
+	return (lower + upper) / 2
+}
+
+func (this *histogram) add(v int64) {
+	this.counts[histIndex(v)]++
+	this.count++
+	if v > this.max {
+		this.max = v
+	}
+}
+
+//quantile q 取值 (0, 1], 结果不超过最大值
+func (this *histogram) quantile(q float64) int64 {
+	if this.count == 0 {
+		return 0
+	}
+	target := uint64(q*float64(this.count) + 0.5)
+	if target == 0 {
+		target = 1
+	}
+	var cum uint64
+	for i, c := range this.counts {
+		cum += uint64(c)
+		if cum >= target {
+			if v := histValue(i); v < this.max {
+				return v
+			}
+			return this.max
+		}
+	}
+	return this.max
+}
@@ -1,23 +1,30 @@
 package metrics
 
 import (
-	"bytes"
 	"common/defs"
 	"common/tlog"
-	"encoding/json"
-	"net/http"
 	"os"
+	"sync"
 	"time"
 )
 
 var m *Metrics
 
+//window 统计周期, 每个周期推送一次
+var window = 60 * time.Second
+
 type Metrics struct {
-	name     string
-	m        map[string]*Counter
-	ch       chan *Event
-	endpoint string
-	client   *http.Client
+	name      string
+	lock      sync.Mutex //保护 m 与 events, Prometheus 抓取时读取
+	m         map[string]*Counter
+	events    map[string]*eventStat
+	ch        chan *Event
+	endpoint  string
+	exporters []Exporter
+	exporting chan struct{} //同一时间只有一轮推送
+
+	eventOverflow uint64 //因超过 cardinalityLimit 记到 _other 的次数, 仅在 run 中修改
+	tlogDropped   uint64
 }
 
 type Data struct {
@@ -31,40 +38,97 @@ type Data struct {
 }
 
 type Counter struct {
-	Total int64 `json:"-"`
-	Count int   `json:"count"`
-	Avg   int64 `json:"avg"`
-	Qps   int   `json:"qps"`
+	Total  int64 `json:"-"`
+	Count  int   `json:"count"`
+	Avg    int64 `json:"avg"`
+	Qps    int   `json:"qps"`
+	Errors int   `json:"errors"`
+	P50    int64 `json:"p50"`
+	P90    int64 `json:"p90"`
+	P99    int64 `json:"p99"`
+	Max    int64 `json:"max"`
+
+	hist *histogram
+}
+
+//eventStat 启动以来的累计值, 供 Prometheus 抓取
+type eventStat struct {
+	count   uint64
+	errors  uint64
+	total   int64    //微秒
+	buckets []uint64 //与 latencyBuckets 对应, 非累计
 }
 
 type Event struct {
 	event string
 	t     int64
+	err   bool
+	done  chan struct{} //Flush 请求, 推送完成后关闭
+}
+
+//Config 指标配置, 所有环境都会统计, 是否推送由 push_url 决定
+type Config struct {
+	Window           int    `toml:"window"`            //统计周期(秒), 默认 60
+	PushUrl          string `toml:"push_url"`          //Falcon push 地址, 为空时生产环境推送到本机 agent, 其他环境不推送
+	BatchSize        int    `toml:"batch_size"`        //每次推送的最大条数, 默认 500
+	Retries          *int   `toml:"retries"`           //推送失败重试次数, 未配置时为 3, 0 表示不重试, 间隔从 500ms 开始翻倍
+	CardinalityLimit int    `toml:"cardinality_limit"` //每个指标的标签组合上限, 默认 1000
+}
+
+//Exporter 每个统计周期结束时推送一次
+type Exporter interface {
+	Export(ds []*Data) error
 }
 
 func Init(name string, env string) {
-	if env != defs.EnvProd {
-		return
-	}
+	InitWithConfig(name, env, Config{})
+}
+
+func InitWithConfig(name string, env string, c Config) {
 	if m == nil {
+		SetWindow(time.Duration(c.Window) * time.Second)
+		SetCardinalityLimit(c.CardinalityLimit)
 		m = &Metrics{
-			name: name,
-			m:    make(map[string]*Counter),
-			ch:   make(chan *Event, 10240),
-			client: &http.Client{
-				Timeout: time.Second,
-				Transport: &http.Transport{
-					MaxIdleConns:          2,
-					IdleConnTimeout:       120 * time.Second,
-					DisableCompression:    true,
-					ResponseHeaderTimeout: 3 * time.Second,
-				},
-			},
+			name:      name,
+			m:         make(map[string]*Counter),
+			events:    make(map[string]*eventStat),
+			ch:        make(chan *Event, 10240),
+			exporting: make(chan struct{}, 1),
 		}
 		m.endpoint, _ = os.Hostname()
+		//未配置时沿用生产环境的本机 Falcon agent
+		if c.PushUrl == "" && env == defs.EnvProd {
+			c.PushUrl = _FalconUrl
+		}
+		if c.PushUrl != "" {
+			retries := -1
+			if c.Retries != nil {
+				retries = *c.Retries
+			}
+			m.exporters = append(m.exporters, NewFalconExporter(c.PushUrl, c.BatchSize, retries))
+		}
 		go m.run()
 		go m.t()
+		RegisterCollector("runtime", RuntimeCollector())
+		RegisterCollector("tlog", TlogCollector())
+	}
+}
+
+//SetWindow 设置统计周期, 在 Init 之前调用
+func SetWindow(d time.Duration) {
+	if d >= time.Second {
+		window = d
+	}
+}
+
+//AddExporter 增加推送目标, 在 Init 之后调用
+func AddExporter(e Exporter) {
+	if m == nil {
+		return
 	}
+	m.lock.Lock()
+	m.exporters = append(m.exporters, e)
+	m.lock.Unlock()
 }
 
 func Add(event string, t time.Time) {
@@ -88,33 +152,81 @@ func AddT(event string, t int64) {
 	}
 }
 
+//AddE err 不为 nil 时计入错误数
+func AddE(event string, t time.Time, err error) {
+	AddTE(event, int64(time.Since(t)/1000), err != nil)
+}
+
+//AddTE t 微秒数, failed 为 true 时计入错误数
+func AddTE(event string, t int64, failed bool) {
+	if m == nil {
+		return
+	}
+	select {
+	case m.ch <- &Event{event: event, t: t, err: failed}:
+	default:
+	}
+}
+
+//Flush 立即结束当前统计周期并等待推送完成, 用于测试与退出前
+func Flush() {
+	if m == nil {
+		return
+	}
+	done := make(chan struct{})
+	m.ch <- &Event{done: done}
+	<-done
+}
+
 func (m *Metrics) run() {
 	for {
 		e := <-m.ch
-		if e == nil {
-			m.p()
+		if e == nil || e.done != nil {
+			m.p(e)
 			continue
 		}
-		counter, ok := m.m[e.event]
-		if ok {
-			counter.Total += e.t
-			counter.Count++
-		} else {
-			m.m[e.event] = &Counter{
-				Total: e.t,
-				Count: 1,
-			}
+		m.lock.Lock()
+		//事件名与带标签的指标一样受 cardinalityLimit 限制, 超过后记到 _other
+		event := e.event
+		if _, ok := m.events[event]; !ok && len(m.events) >= cardinalityLimit {
+			event = OtherLabel
+			m.eventOverflow++
+		}
+		counter, ok := m.m[event]
+		if !ok {
+			counter = &Counter{hist: newHistogram()}
+			m.m[event] = counter
+		}
+		counter.Total += e.t
+		counter.Count++
+		counter.hist.add(e.t)
+		stat, ok := m.events[event]
+		if !ok {
+			stat = &eventStat{buckets: make([]uint64, len(latencyBuckets))}
+			m.events[event] = stat
 		}
+		stat.count++
+		stat.total += e.t
+		if e.err {
+			counter.Errors++
+			stat.errors++
+		}
+		if i := bucketIndex(e.t); i < len(stat.buckets) {
+			stat.buckets[i]++
+		}
+		m.lock.Unlock()
 	}
 }
 
 func (m *Metrics) t() {
-	for range time.NewTicker(60 * time.Second).C {
+	for range time.NewTicker(window).C {
 		m.ch <- nil
 	}
 }
 
-func (m *Metrics) p() {
+//p e 为 Flush 请求时同步推送
+func (m *Metrics) p(e *Event) {
+	m.lock.Lock()
 	for key, c := range m.m {
 		if c.Count == 0 {
 			delete(m.m, key)
@@ -122,41 +234,72 @@ func (m *Metrics) p() {
 		}
 		c.Qps = c.Count
 		c.Avg = c.Total / int64(c.Count)
-
+		c.P50 = c.hist.quantile(0.5)
+		c.P90 = c.hist.quantile(0.9)
+		c.P99 = c.hist.quantile(0.99)
+		c.Max = c.hist.max
 	}
-	if len(m.m) > 0 {
-		ds := []*Data{}
-		for key, c := range m.m {
-			ds = append(ds, &Data{
-				Metric:      m.name,
-				Tags:        "type=" + key,
-				Endpoint:    m.endpoint,
-				Value:       float64(c.Qps),
-				CounterType: "GAUGE",
-				Timestamp:   time.Now().Unix(),
-				Step:        60})
-			ds = append(ds, &Data{
-				Metric:      m.name,
-				Tags:        "type=" + key + ".responsetime",
-				Endpoint:    m.endpoint,
-				Value:       float64(c.Avg),
-				CounterType: "GAUGE",
-				Timestamp:   time.Now().Unix(),
-				Step:        60})
-		}
-		bb, _ := json.Marshal(ds)
-		go report(bb)
-		m.m = make(map[string]*Counter)
+	step := int(window / time.Second)
+	ds := []*Data{}
+	gauge := func(tags string, v float64) {
+		ds = append(ds, &Data{
+			Metric:      m.name,
+			Tags:        tags,
+			Endpoint:    m.endpoint,
+			Value:       v,
+			CounterType: "GAUGE",
+			Timestamp:   time.Now().Unix(),
+			Step:        step})
 	}
-}
+	for key, c := range m.m {
+		//type=key 为周期内的次数, 耗时单位微秒
+		gauge("type="+key, float64(c.Qps))
+		gauge("type="+key+".responsetime", float64(c.Avg))
+		gauge("type="+key+".p50", float64(c.P50))
+		gauge("type="+key+".p90", float64(c.P90))
+		gauge("type="+key+".p99", float64(c.P99))
+		gauge("type="+key+".max", float64(c.Max))
+		gauge("type="+key+".errors", float64(c.Errors))
+	}
+	m.m = make(map[string]*Counter)
+	exporters := m.exporters
+	m.lock.Unlock()
 
-func report(d []byte) {
-	req, _ := http.NewRequest("POST", "http://localhost:1988/v1/push", bytes.NewBuffer(d))
-	req.Header.Add("Content-Type", "application/json")
-	resp, err := m.client.Do(req)
-	if err != nil {
-		tlog.Errorf("falconError||error=%s", err.Error())
+	//本周期内丢弃的日志数, 队列长度由 TlogCollector 采样
+	stat := tlog.Stats()
+	gauge("type=tlog.dropped", float64(stat.Dropped-m.tlogDropped))
+	m.tlogDropped = stat.Dropped
+	ds = append(ds, collectFalcon(m.name, m.endpoint, step)...)
+
+	//上一轮推送未结束时跳过本周期, 避免推送缓慢时协程堆积; Flush 等待上一轮结束
+	if e == nil {
+		select {
+		case m.exporting <- struct{}{}:
+		default:
+			tlog.Errorf("metricsExportError||error=previous export still running, skip %d points", len(ds))
+			return
+		}
 	} else {
-		resp.Body.Close()
+		m.exporting <- struct{}{}
+	}
+	var wg sync.WaitGroup
+	for _, ex := range exporters {
+		wg.Add(1)
+		go func(ex Exporter) {
+			defer wg.Done()
+			if err := ex.Export(ds); err != nil {
+				tlog.Errorf("metricsExportError||error=%s", err.Error())
+			}
+		}(ex)
+	}
+	if e != nil {
+		wg.Wait()
+		<-m.exporting
+		close(e.done)
+		return
 	}
+	go func() {
+		wg.Wait()
+		<-m.exporting
+	}()
 }
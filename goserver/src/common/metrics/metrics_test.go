@@ -0,0 +1,250 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrometheus(t *testing.T) {
+	Init("test", "dev")
+	AddT("login", 2000)
+	AddT("login", 20000000)
+	time.Sleep(50 * time.Millisecond)
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		`event_total{service="test",event="login"} 2`,
+		`event_duration_seconds_bucket{service="test",event="login",le="0.001"} 0`,
+		`event_duration_seconds_bucket{service="test",event="login",le="0.005"} 1`,
+		`event_duration_seconds_bucket{service="test",event="login",le="10"} 1`,
+		`event_duration_seconds_bucket{service="test",event="login",le="+Inf"} 2`,
+		`event_duration_seconds_sum{service="test",event="login"} 20.002`,
+		`tlog_queue{service="test"} 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("missing %s in\n%s", line, body)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram()
+	for i := int64(1); i <= 10000; i++ {
+		h.add(i * 100)
+	}
+	for q, want := range map[float64]int64{0.5: 500000, 0.9: 900000, 0.99: 990000} {
+		got := h.quantile(q)
+		if got < want-want/32 || got > want+want/32 {
+			t.Fatalf("p%v = %d, want %d", q*100, got, want)
+		}
+	}
+	if h.max != 1000000 || h.quantile(1) != 1000000 {
+		t.Fatal("max", h.max, h.quantile(1))
+	}
+	for _, v := range []int64{0, 63, 64, 1 << 20, 1 << 50} {
+		if i := histIndex(v); i < 0 || i >= _HistSize {
+			t.Fatal("index out of range", v, i)
+		}
+	}
+}
+
+func TestTyped(t *testing.T) {
+	Init("test", "dev")
+	SetCardinalityLimit(2)
+	defer SetCardinalityLimit(1000)
+
+	requests := NewCounter("rpc_requests_total", "method", "code")
+	requests.With("GetConf", "0").Inc()
+	requests.With("GetConf", "0").Add(2)
+	requests.With("Ping", "0").Inc()
+	requests.With("Ping", "1").Inc()
+	requests.With("Ping", "2").Inc()
+	NewGauge("pool_size", "db").With("main").Set(1.5)
+	latency := NewTimer("rpc", "method")
+	latency.With("GetConf").Observe(2 * time.Millisecond)
+	latency.With("GetConf").Since(time.Now(), errors.New("failed"))
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		`rpc_requests_total{service="test",method="GetConf",code="0"} 3`,
+		`rpc_requests_total{service="test",method="_other",code="_other"} 2`,
+		`metrics_label_overflow_total{metric="rpc_requests_total"} 2`,
+		`pool_size{service="test",db="main"} 1.5`,
+		`rpc_seconds_bucket{service="test",method="GetConf",le="0.005"} 2`,
+		`rpc_seconds_count{service="test",method="GetConf"} 2`,
+		`rpc_errors_total{service="test",method="GetConf"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("missing %s in\n%s", line, body)
+		}
+	}
+
+	tags := map[string]string{}
+	for _, d := range collectFalcon("test", "host", 60) {
+		tags[d.Metric+" "+d.Tags] = d.CounterType
+	}
+	for _, key := range []string{
+		"rpc_requests_total service=test,method=GetConf,code=0 COUNTER",
+		"pool_size service=test,db=main GAUGE",
+		"rpc.p99 service=test,method=GetConf GAUGE",
+		"rpc.errors service=test,method=GetConf GAUGE",
+	} {
+		i := strings.LastIndex(key, " ")
+		if tags[key[:i]] != key[i+1:] {
+			t.Fatalf("missing falcon %s in %v", key, tags)
+		}
+	}
+}
+
+type testSizer int64
+
+func (this testSizer) Len() int64 {
+	return int64(this)
+}
+
+func TestCollector(t *testing.T) {
+	Init("test", "dev")
+	RegisterCollector("runtime", RuntimeCollector())
+	RegisterCollector("cache", CacheCollector("conf", testSizer(42)))
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `process_cache_items{service="test",cache="conf"} 42`+"\n") {
+		t.Fatal(body)
+	}
+	if !strings.Contains(body, `go_goroutines{service="test"} `) || strings.Contains(body, `go_goroutines{service="test"} 0`+"\n") {
+		t.Fatal(body)
+	}
+}
+
+func TestExporter(t *testing.T) {
+	Init("test", "dev")
+	e := NewMemoryExporter()
+	AddExporter(e)
+	AddTE("order", 3000, true)
+	Flush()
+	if d, ok := e.Find("test", "type=order.errors"); !ok || d.Value != 1 {
+		t.Fatal("order.errors", d, ok)
+	}
+	if d, ok := e.Find("test", "type=order.max"); !ok || d.Value != 3000 {
+		t.Fatal("order.max", d, ok)
+	}
+	if d, ok := e.Find("test", "type=order.p99"); !ok || d.Value < 3000-3000/32 {
+		t.Fatal("order.p99", d, ok)
+	}
+
+	//第一次失败后重试, 500 条分两批
+	var lock sync.Mutex
+	calls, sizes := 0, []int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var ds []*Data
+		json.NewDecoder(r.Body).Decode(&ds)
+		sizes = append(sizes, len(ds))
+	}))
+	defer server.Close()
+	ds := make([]*Data, 500)
+	for i := range ds {
+		ds[i] = &Data{Metric: "m"}
+	}
+	if err := NewFalconExporter(server.URL, 300, 1).Export(ds); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 || len(sizes) != 2 || sizes[0] != 300 || sizes[1] != 200 {
+		t.Fatal(calls, sizes)
+	}
+
+	//retries 为 0 时不重试
+	calls, sizes = 0, nil
+	if err := NewFalconExporter(server.URL, 500, 0).Export(ds); err == nil || calls != 1 {
+		t.Fatal(calls, err)
+	}
+}
+
+func TestEventLimit(t *testing.T) {
+	Init("test", "dev")
+	Flush()
+	m.lock.Lock()
+	n := len(m.events)
+	m.lock.Unlock()
+	SetCardinalityLimit(n + 2)
+	defer SetCardinalityLimit(1000)
+
+	for _, event := range []string{"limit.a", "limit.b", "limit.c", "limit.d", "limit.c"} {
+		AddT(event, 1000)
+	}
+	Flush()
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		`event_total{service="test",event="limit.b"} 1`,
+		`event_total{service="test",event="_other"} 3`,
+		`event_overflow_total{service="test"} 3`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("missing %s in\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, `event="limit.c"`) {
+		t.Fatal("event over limit exported:", body)
+	}
+}
+
+type slowExporter struct {
+	lock    sync.Mutex
+	running int
+	max     int
+}
+
+func (this *slowExporter) Export(ds []*Data) error {
+	this.lock.Lock()
+	this.running++
+	if this.running > this.max {
+		this.max = this.running
+	}
+	this.lock.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	this.lock.Lock()
+	this.running--
+	this.lock.Unlock()
+	return nil
+}
+
+func TestExportInFlight(t *testing.T) {
+	Init("test", "dev")
+	e := &slowExporter{}
+	AddExporter(e)
+	defer func() {
+		m.lock.Lock()
+		m.exporters = m.exporters[:len(m.exporters)-1]
+		m.lock.Unlock()
+	}()
+
+	//周期结束的信号连续到达, 推送缓慢时跳过而不是并发
+	for i := 0; i < 5; i++ {
+		m.ch <- nil
+	}
+	Flush()
+	if e.max != 1 {
+		t.Fatalf("max in flight = %d, want 1", e.max)
+	}
+}
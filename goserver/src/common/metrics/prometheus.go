@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"bytes"
+	"common/tlog"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//latencyBuckets 耗时直方图的上界(秒)
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+//bucketIndex t 为微秒, 超过最大上界返回 len(latencyBuckets)
+func bucketIndex(t int64) int {
+	s := float64(t) / 1e6
+	return sort.SearchFloat64s(latencyBuckets, s)
+}
+
+//Handler Prometheus 文本格式的 /metrics, 未 Init 时不输出 Add 记录的事件
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var b bytes.Buffer
+		service := ""
+		if m != nil {
+			m.writeEvents(&b)
+			service = m.name
+		}
+		writeFamilies(&b, service)
+		writeTlog(&b)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(b.Bytes())
+	})
+}
+
+func (m *Metrics) writeEvents(b *bytes.Buffer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	names := make([]string, 0, len(m.events))
+	for name := range m.events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	service := `service="` + escapeLabel(m.name) + `"`
+
+	b.WriteString("# HELP event_total Number of events.\n# TYPE event_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(b, "event_total{%s,event=\"%s\"} %d\n", service, escapeLabel(name), m.events[name].count)
+	}
+
+	b.WriteString("# HELP event_errors_total Number of failed events.\n# TYPE event_errors_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(b, "event_errors_total{%s,event=\"%s\"} %d\n", service, escapeLabel(name), m.events[name].errors)
+	}
+
+	b.WriteString("# HELP event_duration_seconds Event latency.\n# TYPE event_duration_seconds histogram\n")
+	for _, name := range names {
+		stat := m.events[name]
+		labels := service + `,event="` + escapeLabel(name) + `"`
+		var cum uint64
+		for i, le := range latencyBuckets {
+			cum += stat.buckets[i]
+			fmt.Fprintf(b, "event_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(le), cum)
+		}
+		fmt.Fprintf(b, "event_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, stat.count)
+		fmt.Fprintf(b, "event_duration_seconds_sum{%s} %s\n", labels, formatFloat(float64(stat.total)/1e6))
+		fmt.Fprintf(b, "event_duration_seconds_count{%s} %d\n", labels, stat.count)
+	}
+
+	if m.eventOverflow > 0 {
+		b.WriteString("# HELP event_overflow_total Events recorded as _other because of the cardinality limit.\n# TYPE event_overflow_total counter\n")
+		fmt.Fprintf(b, "event_overflow_total{%s} %d\n", service, m.eventOverflow)
+	}
+}
+
+func writeTlog(b *bytes.Buffer) {
+	stat := tlog.Stats()
+	b.WriteString("# HELP tlog_dropped_total Log messages dropped because the queue was full.\n# TYPE tlog_dropped_total counter\n")
+	fmt.Fprintf(b, "tlog_dropped_total %d\n", stat.Dropped)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
@@ -0,0 +1,388 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	kindCounter = "counter"
+	kindGauge   = "gauge"
+	kindTimer   = "timer"
+)
+
+//超过标签组合上限后新的组合都记到这个值上
+const OtherLabel = "_other"
+
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+//cardinalityLimit 每个指标的标签组合上限
+var cardinalityLimit = 1000
+
+//families 已注册的指标, 名称 => *family
+var families sync.Map
+
+//SetCardinalityLimit 设置之后注册的指标的标签组合上限
+func SetCardinalityLimit(n int) {
+	if n > 0 {
+		cardinalityLimit = n
+	}
+}
+
+//family 一个指标及其所有标签组合
+type family struct {
+	name     string
+	kind     string
+	labels   []string
+	limit    int
+	lock     sync.RWMutex
+	series   map[string]*series
+	overflow uint64 //因超过上限记到 _other 的次数
+}
+
+type series struct {
+	values []string
+	value  uint64 //counter 为整数, gauge 为 float64 的位
+	timer  *timerStat
+}
+
+type timerStat struct {
+	lock    sync.Mutex
+	window  *histogram
+	wsum    int64
+	werrors uint64
+	count   uint64
+	errors  uint64
+	sum     int64    //微秒
+	buckets []uint64 //与 latencyBuckets 对应, 非累计
+}
+
+func register(name string, kind string, labels []string) *family {
+	if !nameRegexp.MatchString(name) {
+		panic("metrics: invalid metric name " + name)
+	}
+	for _, l := range labels {
+		if !nameRegexp.MatchString(l) || strings.HasPrefix(l, "__") {
+			panic("metrics: invalid label name " + l)
+		}
+	}
+	f := &family{name: name, kind: kind, labels: labels, limit: cardinalityLimit, series: make(map[string]*series)}
+	if old, loaded := families.LoadOrStore(name, f); loaded {
+		f = old.(*family)
+		if f.kind != kind || strings.Join(f.labels, ",") != strings.Join(labels, ",") {
+			panic("metrics: " + name + " registered with different type or labels")
+		}
+	}
+	return f
+}
+
+//get 返回标签值对应的序列, 个数不足的补空, 超过上限时返回 _other 序列
+func (this *family) get(values []string) *series {
+	if len(values) != len(this.labels) {
+		vs := make([]string, len(this.labels))
+		copy(vs, values)
+		values = vs
+	}
+	key := strings.Join(values, "\xff")
+	this.lock.RLock()
+	s, ok := this.series[key]
+	this.lock.RUnlock()
+	if ok {
+		return s
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if s, ok = this.series[key]; ok {
+		return s
+	}
+	if len(this.series) >= this.limit {
+		atomic.AddUint64(&this.overflow, 1)
+		values = make([]string, len(this.labels))
+		for i := range values {
+			values[i] = OtherLabel
+		}
+		key = strings.Join(values, "\xff")
+		if s, ok = this.series[key]; ok {
+			return s
+		}
+	}
+	s = &series{values: append([]string(nil), values...)}
+	if this.kind == kindTimer {
+		s.timer = &timerStat{window: newHistogram(), buckets: make([]uint64, len(latencyBuckets))}
+	}
+	this.series[key] = s
+	return s
+}
+
+//sorted 按标签值排序的序列, 输出稳定
+func (this *family) sorted() []*series {
+	this.lock.RLock()
+	ss := make([]*series, 0, len(this.series))
+	for _, s := range this.series {
+		ss = append(ss, s)
+	}
+	this.lock.RUnlock()
+	sort.Slice(ss, func(i, j int) bool {
+		return strings.Join(ss[i].values, "\xff") < strings.Join(ss[j].values, "\xff")
+	})
+	return ss
+}
+
+//CounterVec 只增不减的计数
+type CounterVec struct {
+	f *family
+}
+
+type CounterSeries struct {
+	s *series
+}
+
+func NewCounter(name string, labels ...string) *CounterVec {
+	return &CounterVec{f: register(name, kindCounter, labels)}
+}
+
+func (this *CounterVec) With(values ...string) *CounterSeries {
+	return &CounterSeries{s: this.f.get(values)}
+}
+
+func (this *CounterSeries) Inc() {
+	atomic.AddUint64(&this.s.value, 1)
+}
+
+func (this *CounterSeries) Add(n uint64) {
+	atomic.AddUint64(&this.s.value, n)
+}
+
+//GaugeVec 可任意设置的瞬时值
+type GaugeVec struct {
+	f *family
+}
+
+type GaugeSeries struct {
+	s *series
+}
+
+func NewGauge(name string, labels ...string) *GaugeVec {
+	return &GaugeVec{f: register(name, kindGauge, labels)}
+}
+
+func (this *GaugeVec) With(values ...string) *GaugeSeries {
+	return &GaugeSeries{s: this.f.get(values)}
+}
+
+func (this *GaugeSeries) Set(v float64) {
+	atomic.StoreUint64(&this.s.value, math.Float64bits(v))
+}
+
+func (this *GaugeSeries) Add(v float64) {
+	for {
+		old := atomic.LoadUint64(&this.s.value)
+		n := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&this.s.value, old, n) {
+			return
+		}
+	}
+}
+
+//TimerVec 耗时分布与错误数
+type TimerVec struct {
+	f *family
+}
+
+type TimerSeries struct {
+	s *series
+}
+
+func NewTimer(name string, labels ...string) *TimerVec {
+	return &TimerVec{f: register(name, kindTimer, labels)}
+}
+
+func (this *TimerVec) With(values ...string) *TimerSeries {
+	return &TimerSeries{s: this.f.get(values)}
+}
+
+func (this *TimerSeries) Observe(d time.Duration) {
+	this.observe(int64(d/time.Microsecond), false)
+}
+
+//Since 记录从 t 到现在的耗时, err 不为 nil 时计入错误数
+func (this *TimerSeries) Since(t time.Time, err error) {
+	this.observe(int64(time.Since(t)/time.Microsecond), err != nil)
+}
+
+func (this *TimerSeries) observe(us int64, failed bool) {
+	t := this.s.timer
+	t.lock.Lock()
+	t.window.add(us)
+	t.wsum += us
+	t.count++
+	t.sum += us
+	if failed {
+		t.werrors++
+		t.errors++
+	}
+	if i := bucketIndex(us); i < len(t.buckets) {
+		t.buckets[i]++
+	}
+	t.lock.Unlock()
+}
+
+//sortedFamilies 按名称排序的所有指标
+func sortedFamilies() []*family {
+	var fs []*family
+	families.Range(func(k, v interface{}) bool {
+		fs = append(fs, v.(*family))
+		return true
+	})
+	sort.Slice(fs, func(i, j int) bool { return fs[i].name < fs[j].name })
+	return fs
+}
+
+//falconTags 标签按注册顺序拼成 k=v,k=v, 值中的 , = 替换为 _
+func (this *family) falconTags(s *series, service string) string {
+	var b strings.Builder
+	if service != "" && !this.hasLabel("service") {
+		b.WriteString("service=" + falconEscaper.Replace(service))
+	}
+	for i, l := range this.labels {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l + "=" + falconEscaper.Replace(s.values[i]))
+	}
+	return b.String()
+}
+
+var falconEscaper = strings.NewReplacer(",", "_", "=", "_", " ", "_")
+
+func (this *family) hasLabel(name string) bool {
+	for _, l := range this.labels {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
+
+//collectFalcon 生成一个周期的推送数据并重置计时器的周期统计;
+//计数器按 COUNTER 推送累计值, 由 Falcon 计算速率
+func collectFalcon(service string, endpoint string, step int) []*Data {
+	var ds []*Data
+	now := time.Now().Unix()
+	for _, f := range sortedFamilies() {
+		for _, s := range f.sorted() {
+			tags := f.falconTags(s, service)
+			add := func(metric string, typ string, v float64) {
+				ds = append(ds, &Data{Metric: metric, Tags: tags, Endpoint: endpoint, Value: v,
+					CounterType: typ, Timestamp: now, Step: step})
+			}
+			switch f.kind {
+			case kindCounter:
+				add(f.name, "COUNTER", float64(atomic.LoadUint64(&s.value)))
+			case kindGauge:
+				add(f.name, "GAUGE", math.Float64frombits(atomic.LoadUint64(&s.value)))
+			case kindTimer:
+				t := s.timer
+				t.lock.Lock()
+				h, sum, errors := t.window, t.wsum, t.werrors
+				t.window, t.wsum, t.werrors = newHistogram(), 0, 0
+				t.lock.Unlock()
+				if h.count == 0 {
+					continue
+				}
+				//耗时单位微秒
+				add(f.name+".count", "GAUGE", float64(h.count))
+				add(f.name+".avg", "GAUGE", float64(sum/int64(h.count)))
+				add(f.name+".p50", "GAUGE", float64(h.quantile(0.5)))
+				add(f.name+".p90", "GAUGE", float64(h.quantile(0.9)))
+				add(f.name+".p99", "GAUGE", float64(h.quantile(0.99)))
+				add(f.name+".max", "GAUGE", float64(h.max))
+				add(f.name+".errors", "GAUGE", float64(errors))
+			}
+		}
+	}
+	return ds
+}
+
+//promLabels 标签输出为 {k="v",...}, 未声明 service 标签时加上服务名
+func (this *family) promLabels(s *series, service string, extra string) string {
+	var parts []string
+	if service != "" && !this.hasLabel("service") {
+		parts = append(parts, `service="`+escapeLabel(service)+`"`)
+	}
+	for i, l := range this.labels {
+		parts = append(parts, l+`="`+escapeLabel(s.values[i])+`"`)
+	}
+	if extra != "" {
+		parts = append(parts, extra)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+//writeFamilies Prometheus 文本格式, 计时器输出为秒为单位的直方图
+func writeFamilies(b *bytes.Buffer, service string) {
+	overflow := false
+	for _, f := range sortedFamilies() {
+		if atomic.LoadUint64(&f.overflow) > 0 {
+			overflow = true
+		}
+		switch f.kind {
+		case kindCounter:
+			fmt.Fprintf(b, "# TYPE %s counter\n", f.name)
+			for _, s := range f.sorted() {
+				fmt.Fprintf(b, "%s%s %d\n", f.name, f.promLabels(s, service, ""), atomic.LoadUint64(&s.value))
+			}
+		case kindGauge:
+			fmt.Fprintf(b, "# TYPE %s gauge\n", f.name)
+			for _, s := range f.sorted() {
+				fmt.Fprintf(b, "%s%s %s\n", f.name, f.promLabels(s, service, ""),
+					formatFloat(math.Float64frombits(atomic.LoadUint64(&s.value))))
+			}
+		case kindTimer:
+			ss := f.sorted()
+			fmt.Fprintf(b, "# TYPE %s_seconds histogram\n", f.name)
+			for _, s := range ss {
+				t := s.timer
+				t.lock.Lock()
+				count, sum := t.count, t.sum
+				buckets := append([]uint64(nil), t.buckets...)
+				t.lock.Unlock()
+				var cum uint64
+				for i, le := range latencyBuckets {
+					cum += buckets[i]
+					fmt.Fprintf(b, "%s_seconds_bucket%s %d\n", f.name, f.promLabels(s, service, `le="`+formatFloat(le)+`"`), cum)
+				}
+				fmt.Fprintf(b, "%s_seconds_bucket%s %d\n", f.name, f.promLabels(s, service, `le="+Inf"`), count)
+				fmt.Fprintf(b, "%s_seconds_sum%s %s\n", f.name, f.promLabels(s, service, ""), formatFloat(float64(sum)/1e6))
+				fmt.Fprintf(b, "%s_seconds_count%s %d\n", f.name, f.promLabels(s, service, ""), count)
+			}
+			fmt.Fprintf(b, "# TYPE %s_errors_total counter\n", f.name)
+			for _, s := range ss {
+				s.timer.lock.Lock()
+				errors := s.timer.errors
+				s.timer.lock.Unlock()
+				fmt.Fprintf(b, "%s_errors_total%s %d\n", f.name, f.promLabels(s, service, ""), errors)
+			}
+		}
+	}
+	if overflow {
+		b.WriteString("# HELP metrics_label_overflow_total Observations recorded as _other because of the label cardinality limit.\n")
+		b.WriteString("# TYPE metrics_label_overflow_total counter\n")
+		for _, f := range sortedFamilies() {
+			if n := atomic.LoadUint64(&f.overflow); n > 0 {
+				fmt.Fprintf(b, "metrics_label_overflow_total{metric=\"%s\"} %d\n", f.name, n)
+			}
+		}
+	}
+}
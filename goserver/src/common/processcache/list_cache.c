@@ -327,3 +327,17 @@ int CListCacheClean(void* _c, void* msgBuffer)
 	}
 	return 0;
 }
+
+long long CListCacheCount(void* _c)
+{
+	CListCache* c = (CListCache*)_c;
+	long long count = 0;
+	uint32_t i;
+	for (i = 0; i < c->bucketCount; i++) {
+		CListStorage* stor = &c->buckets[i];
+		pthread_rwlock_rdlock(&stor->lock);
+		count += tommy_hashdyn_count(&stor->map);
+		pthread_rwlock_unlock(&stor->lock);
+	}
+	return count;
+}
@@ -74,6 +74,11 @@ func (this *ListCache) Trim(key string, remainCount int) {
 	C.CListCacheTrim(this.cache, unsafe.Pointer(&keydata[0]), keylen, C.int(remainCount))
 }
 
+//Len 缓存中的 key 数, 包含已过期未清理的
+func (this *ListCache) Len() int64 {
+	return int64(C.CListCacheCount(this.cache))
+}
+
 func NewListCache(bucketCount uint32, cleanDuration time.Duration) *ListCache {
 	interval := cleanDuration / time.Duration(bucketCount)
 	if interval < 10*time.Millisecond {
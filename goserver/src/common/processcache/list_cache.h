@@ -10,5 +10,6 @@ void  CListCacheRem(void* cache, const void* key, int keyLen, const void* data,
 void  CListCacheTrim(void* cache, const void* key, int keyLen, int count);
 int   CListCacheGet(void* cache, const void* key, int keyLen, void** data, int* dataLen);
 int   CListCacheClean(void* cache, void* msgBuffer);
+long long CListCacheCount(void* cache);
 
 #endif
@@ -239,3 +239,17 @@ int CProcessCacheClean(void* _c, void* msgBuffer)
 	}
 	return 0;
 }
+
+long long CProcessCacheCount(void* _c)
+{
+	CProcessCache* c = (CProcessCache*)_c;
+	long long count = 0;
+	uint32_t i;
+	for (i = 0; i < c->bucketCount; i++) {
+		CProcessStorage* stor = &c->buckets[i];
+		pthread_rwlock_rdlock(&stor->lock);
+		count += tommy_hashdyn_count(&stor->map);
+		pthread_rwlock_unlock(&stor->lock);
+	}
+	return count;
+}
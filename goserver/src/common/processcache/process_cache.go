@@ -42,6 +42,11 @@ func (this *ProcessCache) Get(key string) (data []byte, found bool) {
 	}
 }
 
+//Len 缓存中的条目数, 包含已过期未清理的
+func (this *ProcessCache) Len() int64 {
+	return int64(C.CProcessCacheCount(this.cache))
+}
+
 func NewProcessCache(bucketCount uint32, cleanDuration time.Duration) *ProcessCache {
 	interval := cleanDuration / time.Duration(bucketCount)
 	if interval < 10*time.Millisecond {
@@ -8,5 +8,6 @@ void  CProcessCacheDestroy(void* cache);
 void  CProcessCacheSet(void* cache, const void* key, int keyLen, const void* data, int dataLen, long long expireUnixTime);
 int   CProcessCacheGet(void* cache, const void* key, int keyLen, void** data, int* dataLen);
 int   CProcessCacheClean(void* cache, void* msgBuffer);
+long long CProcessCacheCount(void* cache);
 
 #endif
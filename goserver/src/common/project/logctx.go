@@ -0,0 +1,43 @@
+package project
+
+import (
+	"common/defs"
+	"common/tlog"
+	"common/util"
+	"context"
+
+	"github.com/gin-gonic/gin"
+)
+
+//LogContext gin 中间件, 把 request id / trace id / 平台写入请求的 context,
+//handler 中通过 tlog.Ctx(project.LogCtx(c)) 记录日志即可自动带上这些字段
+func LogContext() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestId := c.GetHeader(defs.HeaderRequestId)
+		if requestId == "" {
+			requestId = util.GenerateUUID()
+		}
+		traceId := c.GetHeader(defs.HeaderTraceId)
+		if traceId == "" {
+			traceId = requestId
+		}
+		ctx := tlog.NewContext(c.Request.Context(),
+			tlog.String(tlog.KeyRequestId, requestId),
+			tlog.String(tlog.KeyTraceId, traceId),
+			tlog.Int32(tlog.KeyPlatform, GetPlatformId(c)),
+		)
+		c.Request = c.Request.WithContext(ctx)
+		c.Header(defs.HeaderRequestId, requestId)
+		c.Next()
+	}
+}
+
+//SetLogUid 登录校验通过后把 uid 写入请求的日志字段
+func SetLogUid(c *gin.Context, uid int64) {
+	c.Request = c.Request.WithContext(tlog.NewContext(c.Request.Context(), tlog.Int64(tlog.KeyUid, uid)))
+}
+
+//LogCtx 返回携带日志字段的请求 context, 也可以作为 gRPC 调用的 parent context
+func LogCtx(c *gin.Context) context.Context {
+	return c.Request.Context()
+}
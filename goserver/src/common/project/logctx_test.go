@@ -0,0 +1,51 @@
+package project
+
+import (
+	"common/defs"
+	"common/tlog"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLogContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var ctx context.Context
+	r := gin.New()
+	r.Use(LogContext())
+	r.GET("/", func(c *gin.Context) {
+		SetLogUid(c, 10086)
+		ctx = LogCtx(c)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(defs.HeaderRequestId, "req-1")
+	req.Header.Set("Client-Type", "android")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Header().Get(defs.HeaderRequestId) != "req-1" {
+		t.Fatalf("unexpected response header %v", w.Header())
+	}
+	want := map[string]interface{}{
+		tlog.KeyRequestId: "req-1",
+		tlog.KeyTraceId:   "req-1",
+		tlog.KeyUid:       int64(10086),
+		tlog.KeyPlatform:  int32(defs.PlatformAndroid),
+	}
+	for key, v := range want {
+		if got, ok := tlog.FieldFromContext(ctx, key); !ok || got != v {
+			t.Errorf("field %s = %v, want %v", key, got, v)
+		}
+	}
+
+	//没有请求头时生成 request id
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Header().Get(defs.HeaderRequestId) == "" {
+		t.Fatal("request id not generated")
+	}
+}
@@ -72,11 +72,12 @@ func (this *rocketmqLogger) Error(msg string, fields map[string]interface{}) {
 	}
 }
 
+//Fatal 客户端的 Fatal 日志按 ERROR 记录, tlog.Fatal 会退出进程, 不能由第三方库触发
 func (this *rocketmqLogger) Fatal(msg string, fields map[string]interface{}) {
 	if msg == "" && len(fields) == 0 {
 		return
 	}
 	if this.level <= tlog.FATAL {
-		tlog.Fatal(msg, fields)
+		tlog.Error("[rocketmq fatal]", msg, fields)
 	}
 }
@@ -0,0 +1,68 @@
+package tlog
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var signalOnce sync.Once
+
+type levelResult struct {
+	Level    string            `json:"level"`
+	Packages map[string]string `json:"packages"`
+	Error    string            `json:"error,omitempty"`
+}
+
+//LevelHandler 日志级别的管理接口
+//  GET              查看全局与各前缀的级别
+//  POST level=DEBUG 修改全局级别
+//  POST level=DEBUG&prefix=discovery/  修改前缀级别, level 为空则删除该前缀
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		status := http.StatusOK
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost, http.MethodPut:
+			level, prefix := r.FormValue("level"), r.FormValue("prefix")
+			if prefix == "" {
+				err = SetLevel(level)
+			} else {
+				err = SetPackageLevel(prefix, level)
+			}
+			if err == nil {
+				Warningf("Log Level Changed: level=%s prefix=%s remote=%s", level, prefix, r.RemoteAddr)
+			} else {
+				status = http.StatusBadRequest
+			}
+		default:
+			status = http.StatusMethodNotAllowed
+		}
+
+		res := levelResult{Level: GetLevel(), Packages: Levels()}
+		delete(res.Packages, "")
+		if err != nil {
+			res.Error = err.Error()
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(res)
+	})
+}
+
+//watchSignal SIGUSR1 在 DEBUG 与配置级别之间切换
+func watchSignal() {
+	signalOnce.Do(func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGUSR1)
+		go func() {
+			for range sigs {
+				Warningf("Log Level Toggled By SIGUSR1: level=%s", ToggleDebug())
+			}
+		}()
+	})
+}
@@ -0,0 +1,99 @@
+package tlog
+
+import "context"
+
+//请求级别字段的 key, 由 gin 中间件和 gRPC 拦截器写入 context
+const (
+	KeyRequestId = "request_id"
+	KeyTraceId   = "trace_id"
+	KeyUid       = "uid"
+	KeyPlatform  = "platform"
+)
+
+type ctxFieldsKey struct{}
+
+//NewContext 返回携带日志字段的 context, 已有字段的同名 key 会被覆盖
+func NewContext(ctx context.Context, fields ...Field) context.Context {
+	old := FromContext(ctx)
+	fs := make([]Field, 0, len(old)+len(fields))
+	for _, f := range old {
+		replaced := false
+		for _, nf := range fields {
+			if nf.Key == f.Key {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			fs = append(fs, f)
+		}
+	}
+	fs = append(fs, fields...)
+	return context.WithValue(ctx, ctxFieldsKey{}, fs)
+}
+
+//FromContext 取出 context 中的日志字段
+func FromContext(ctx context.Context) []Field {
+	if ctx == nil {
+		return nil
+	}
+	fs, _ := ctx.Value(ctxFieldsKey{}).([]Field)
+	return fs
+}
+
+//FieldFromContext 取出 context 中指定 key 的字段值
+func FieldFromContext(ctx context.Context, key string) (interface{}, bool) {
+	for _, f := range FromContext(ctx) {
+		if f.Key == key {
+			return f.Value, true
+		}
+	}
+	return nil, false
+}
+
+//Ctx 创建携带 context 字段的日志入口, eg. tlog.Ctx(ctx).With(tlog.Int64("id", id)).Error(err)
+func Ctx(ctx context.Context) *Entry {
+	return &Entry{fields: FromContext(ctx)}
+}
+
+func DebugCtx(ctx context.Context, args ...interface{}) {
+	l.p(DEBUG, FromContext(ctx), args...)
+}
+
+func DebugfCtx(ctx context.Context, format string, args ...interface{}) {
+	l.pf(DEBUG, FromContext(ctx), format, args...)
+}
+
+func InfoCtx(ctx context.Context, args ...interface{}) {
+	l.p(INFO, FromContext(ctx), args...)
+}
+
+func InfofCtx(ctx context.Context, format string, args ...interface{}) {
+	l.pf(INFO, FromContext(ctx), format, args...)
+}
+
+func WarningCtx(ctx context.Context, args ...interface{}) {
+	l.p(WARNING, FromContext(ctx), args...)
+}
+
+func WarningfCtx(ctx context.Context, format string, args ...interface{}) {
+	l.pf(WARNING, FromContext(ctx), format, args...)
+}
+
+func ErrorCtx(ctx context.Context, args ...interface{}) {
+	l.p(ERROR, FromContext(ctx), args...)
+}
+
+func ErrorfCtx(ctx context.Context, format string, args ...interface{}) {
+	l.pf(ERROR, FromContext(ctx), format, args...)
+}
+
+func FatalCtx(ctx context.Context, args ...interface{}) {
+	l.p(FATAL, FromContext(ctx), args...)
+	fatalExit()
+}
+
+func FatalfCtx(ctx context.Context, format string, args ...interface{}) {
+	l.pf(FATAL, FromContext(ctx), format, args...)
+	fatalExit()
+}
@@ -0,0 +1,56 @@
+package tlog
+
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+//exitFunc 测试中可替换
+var exitFunc = os.Exit
+
+//fatalExit FATAL 日志之后同步写完所有输出目标并退出进程
+func fatalExit() {
+	Close()
+	exitFunc(1)
+}
+
+//ErrorWithStack 记录 ERROR 日志, 并附带当前协程的调用栈
+func ErrorWithStack(args ...interface{}) {
+	l.p(ERROR, nil, append(args, "\n"+string(debug.Stack()))...)
+}
+
+//ErrorfWithStack 记录 ERROR 日志, 并附带当前协程的调用栈
+func ErrorfWithStack(format string, args ...interface{}) {
+	l.pf(ERROR, nil, format+"\n%s", append(args, debug.Stack())...)
+}
+
+//CatchPanic 记录 panic 及所有协程的调用栈, 同步写完日志后继续 panic
+//用法: defer tlog.CatchPanic()
+func CatchPanic() {
+	if r := recover(); r != nil {
+		buf := make([]byte, 1<<20)
+		buf = buf[:runtime.Stack(buf, true)]
+		file, line := panicCaller()
+		l.pfAt(file, line, FATAL, nil, "panic: %v\n%s", r, buf)
+		Flush()
+		panic(r)
+	}
+}
+
+//panicCaller 跳过 runtime 的帧, 返回触发 panic 的位置
+func panicCaller() (string, int) {
+	pcs := make([]uintptr, 32)
+	//跳过 runtime.Callers, panicCaller 与 CatchPanic
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		f, more := frames.Next()
+		if !strings.HasPrefix(f.Function, "runtime.") {
+			return shortFile(f.File), f.Line
+		}
+		if !more {
+			return "???", 0
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package tlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+//Field 结构化日志的一个键值对
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func String(key string, val string) Field {
+	return Field{Key: key, Value: val}
+}
+
+func Int(key string, val int) Field {
+	return Field{Key: key, Value: val}
+}
+
+func Int32(key string, val int32) Field {
+	return Field{Key: key, Value: val}
+}
+
+func Int64(key string, val int64) Field {
+	return Field{Key: key, Value: val}
+}
+
+func Float64(key string, val float64) Field {
+	return Field{Key: key, Value: val}
+}
+
+func Bool(key string, val bool) Field {
+	return Field{Key: key, Value: val}
+}
+
+//Duration 以毫秒记录耗时, 便于日志管道直接按数值提取
+func Duration(key string, val time.Duration) Field {
+	return Field{Key: key, Value: float64(val) / float64(time.Millisecond)}
+}
+
+//Err 记录错误, key 固定为 error
+func Err(err error) Field {
+	if err == nil {
+		return Field{Key: "error", Value: nil}
+	}
+	return Field{Key: "error", Value: err.Error()}
+}
+
+func Any(key string, val interface{}) Field {
+	return Field{Key: key, Value: val}
+}
+
+//Entry 携带一组字段的日志入口, eg. tlog.With(tlog.Int64("uid", uid)).Info("login")
+type Entry struct {
+	fields []Field
+}
+
+//With 创建携带字段的日志入口
+func With(fields ...Field) *Entry {
+	return &Entry{fields: fields}
+}
+
+//With 在当前字段基础上追加字段, 不影响原 Entry
+func (e *Entry) With(fields ...Field) *Entry {
+	fs := make([]Field, 0, len(e.fields)+len(fields))
+	fs = append(fs, e.fields...)
+	fs = append(fs, fields...)
+	return &Entry{fields: fs}
+}
+
+func (e *Entry) Debug(args ...interface{}) {
+	l.p(DEBUG, e.fields, args...)
+}
+
+func (e *Entry) Debugf(format string, args ...interface{}) {
+	l.pf(DEBUG, e.fields, format, args...)
+}
+
+func (e *Entry) Info(args ...interface{}) {
+	l.p(INFO, e.fields, args...)
+}
+
+func (e *Entry) Infof(format string, args ...interface{}) {
+	l.pf(INFO, e.fields, format, args...)
+}
+
+func (e *Entry) Warning(args ...interface{}) {
+	l.p(WARNING, e.fields, args...)
+}
+
+func (e *Entry) Warningf(format string, args ...interface{}) {
+	l.pf(WARNING, e.fields, format, args...)
+}
+
+func (e *Entry) Error(args ...interface{}) {
+	l.p(ERROR, e.fields, args...)
+}
+
+func (e *Entry) Errorf(format string, args ...interface{}) {
+	l.pf(ERROR, e.fields, format, args...)
+}
+
+func (e *Entry) Fatal(args ...interface{}) {
+	l.p(FATAL, e.fields, args...)
+	fatalExit()
+}
+
+func (e *Entry) Fatalf(format string, args ...interface{}) {
+	l.pf(FATAL, e.fields, format, args...)
+	fatalExit()
+}
+
+//writeTextFields 文本格式: 在消息后追加 key=value, 含空格等字符的值加引号
+func writeTextFields(w *bytes.Buffer, fields []Field) {
+	for _, f := range fields {
+		w.WriteByte(' ')
+		w.WriteString(f.Key)
+		w.WriteByte('=')
+		var s string
+		switch v := f.Value.(type) {
+		case string:
+			s = v
+		case error:
+			s = v.Error()
+		case float64:
+			s = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			s = fmt.Sprint(v)
+		}
+		if s == "" || bytes.ContainsAny([]byte(s), " \t\r\n\"=") {
+			s = strconv.Quote(s)
+		}
+		w.WriteString(s)
+	}
+}
+
+func textFields(fields []Field) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	var w bytes.Buffer
+	writeTextFields(&w, fields)
+	return w.String()
+}
+
+//writeJSONString 写入转义后的 JSON 字符串
+func writeJSONString(w *bytes.Buffer, s string) {
+	b, _ := json.Marshal(s)
+	w.Write(b)
+}
+
+//writeJSONValue 写入 JSON 值, 无法序列化时退化为字符串
+func writeJSONValue(w *bytes.Buffer, v interface{}) {
+	if err, ok := v.(error); ok {
+		writeJSONString(w, err.Error())
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		writeJSONString(w, fmt.Sprint(v))
+		return
+	}
+	w.Write(b)
+}
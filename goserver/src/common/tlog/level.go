@@ -1,5 +1,13 @@
 package tlog
 
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
 type LEVEL byte
 
 const (
@@ -20,19 +28,121 @@ var levelText = map[LEVEL]string{
 	FATAL:   "FATAL",
 }
 
-func getLevel(level string) LEVEL {
-	switch level {
+var ErrInvalidLevel = errors.New("invalid log level, expect one of ALL/DEBUG/INFO/WARNING/ERROR/FATAL")
+
+var (
+	//全局日志级别, 运行期可修改
+	curLevel int32
+	//配置文件中的日志级别, 用于 SIGUSR1 在 DEBUG 和配置级别之间切换
+	baseLevel int32
+	//按包或文件前缀设置的日志级别, []prefixLevel, 前缀长的优先
+	prefixLevels atomic.Value
+	prefixLock   sync.Mutex
+)
+
+type prefixLevel struct {
+	prefix string
+	level  LEVEL
+}
+
+func (lv LEVEL) String() string {
+	return levelText[lv]
+}
+
+//ParseLevel 解析日志级别, 不区分大小写, WARN 等同于 WARNING
+func ParseLevel(level string) (LEVEL, error) {
+	switch strings.ToUpper(strings.TrimSpace(level)) {
+	case "ALL":
+		return ALL, nil
 	case "DEBUG":
-		return DEBUG
+		return DEBUG, nil
 	case "INFO":
-		return INFO
-	case "WARNING":
-		return WARNING
+		return INFO, nil
+	case "WARNING", "WARN":
+		return WARNING, nil
 	case "ERROR":
-		return ERROR
+		return ERROR, nil
 	case "FATAL":
-		return FATAL
+		return FATAL, nil
 	default:
-		return ALL
+		return ALL, ErrInvalidLevel
+	}
+}
+
+//SetLevel 运行期修改全局日志级别
+func SetLevel(level string) error {
+	lv, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt32(&curLevel, int32(lv))
+	return nil
+}
+
+//GetLevel 当前的全局日志级别
+func GetLevel() string {
+	return LEVEL(atomic.LoadInt32(&curLevel)).String()
+}
+
+//SetPackageLevel 按文件前缀设置日志级别, eg. "discovery/" 或 "discovery/balancer.go",
+//前缀与日志中的 {dir}/{file} 匹配; level 为空则删除该前缀的设置
+func SetPackageLevel(prefix string, level string) error {
+	if prefix == "" {
+		return errors.New("empty package prefix")
+	}
+	var lv LEVEL
+	if level != "" {
+		var err error
+		if lv, err = ParseLevel(level); err != nil {
+			return err
+		}
+	}
+
+	prefixLock.Lock()
+	defer prefixLock.Unlock()
+	old, _ := prefixLevels.Load().([]prefixLevel)
+	pls := make([]prefixLevel, 0, len(old)+1)
+	for _, pl := range old {
+		if pl.prefix != prefix {
+			pls = append(pls, pl)
+		}
+	}
+	if level != "" {
+		pls = append(pls, prefixLevel{prefix: prefix, level: lv})
+	}
+	sort.Slice(pls, func(i, j int) bool { return len(pls[i].prefix) > len(pls[j].prefix) })
+	prefixLevels.Store(pls)
+	return nil
+}
+
+//Levels 全局级别(key 为空字符串)与各前缀的级别
+func Levels() map[string]string {
+	m := map[string]string{"": GetLevel()}
+	pls, _ := prefixLevels.Load().([]prefixLevel)
+	for _, pl := range pls {
+		m[pl.prefix] = pl.level.String()
+	}
+	return m
+}
+
+//ToggleDebug 在 DEBUG 与配置文件的级别之间切换, 返回切换后的级别
+func ToggleDebug() string {
+	if LEVEL(atomic.LoadInt32(&curLevel)) == DEBUG {
+		atomic.StoreInt32(&curLevel, atomic.LoadInt32(&baseLevel))
+	} else {
+		atomic.StoreInt32(&curLevel, int32(DEBUG))
+	}
+	return GetLevel()
+}
+
+//enabled 判断 file 处的 level 日志是否需要输出
+func enabled(level LEVEL, file string) bool {
+	if pls, _ := prefixLevels.Load().([]prefixLevel); len(pls) > 0 {
+		for _, pl := range pls {
+			if strings.HasPrefix(file, pl.prefix) {
+				return level >= pl.level
+			}
+		}
 	}
+	return level >= LEVEL(atomic.LoadInt32(&curLevel))
 }
@@ -1,75 +1,105 @@
 package tlog
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
-	"log/syslog"
 	"os"
-	"os/exec"
-	"path"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var l *Logger
 var mu sync.Mutex
 
+//Close 与 Flush 等待写入完成的最长时间
+const _SyncTimeout = 5 * time.Second
+
 type Logger struct {
-	fileSize  int64
-	fileNum   int
-	fileName  string
-	dir       string
-	host      string
-	debug     bool
-	level     LEVEL
-	byteBuff  bytes.Buffer
-	bytePool  *sync.Pool
-	ch        chan *Msg
-	f         *os.File
-	w         *bufio.Writer
-	useSyslog bool
-	syslogW   *syslog.Writer
+	host     string
+	debug    bool
+	format   string
+	textBuff bytes.Buffer
+	jsonBuff bytes.Buffer
+	bytePool *sync.Pool
+	ch       chan *Msg
+	sinks    atomic.Value //[]*sinkEntry, 写时复制
+	closed   int32
+	quit     chan struct{}
+	exited   chan struct{} //writeLoop 退出后关闭
+	samplers [FATAL + 1]*sampler
+	sites    sync.Map //siteKey => *siteCounter
+
+	overflow     string
+	blockTimeout time.Duration
+	dropBelow    LEVEL
+	dropReport   time.Duration
+	dropped      [FATAL + 1]uint64
+	reported     [FATAL + 1]uint64
+	lastReport   time.Time
 }
 
 type Msg struct {
-	line  int
-	file  string
-	level LEVEL
-	msg   []byte
+	line   int
+	file   string
+	level  LEVEL
+	msg    []byte
+	fields []Field
+
+	done  chan struct{} //同步刷新请求, 处理完成后关闭
+	close bool          //同步刷新后关闭所有输出目标
 }
 
 func newLogger(config Config) {
 	l = &Logger{
-		dir:      config.Dir,
-		fileSize: int64(config.FileSize * 1024 * 1024),
-		fileNum:  config.FileNum,
-		fileName: path.Join(config.Dir, config.FileName+".log"),
 		debug:    config.Debug,
-		level:    getLevel(config.Level),
+		format:   config.Format,
 		ch:       make(chan *Msg, 102400),
+		quit:     make(chan struct{}),
+		exited:   make(chan struct{}),
 		bytePool: &sync.Pool{New: func() interface{} { return new(bytes.Buffer) }},
+
+		overflow:     config.Overflow,
+		blockTimeout: time.Duration(config.BlockTimeout) * time.Millisecond,
+		dropReport:   time.Duration(config.DropReport) * time.Second,
+		lastReport:   time.Now(),
 	}
+	l.dropBelow, _ = ParseLevel(config.DropBelow)
+	l.samplers = newSamplers(config.Sampling)
 	l.host, _ = os.Hostname()
+	l.sinks.Store([]*sinkEntry{})
 	if l.debug {
 		return
 	}
 
-	os.MkdirAll(l.dir, 0755)
-	l.f, _ = os.OpenFile(l.fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	l.w = bufio.NewWriterSize(l.f, 1024*1024)
-
-	if config.UseSyslog {
-		var err error
-		l.syslogW, err = syslog.New(syslog.LOG_LOCAL3|syslog.LOG_INFO, config.SyslogTag)
-		if err == nil {
-			l.useSyslog = true
+	for _, sc := range config.sinkConfigs() {
+		s, err := newSink(sc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tlog: create %s sink error: %s\n", sc.Type, err.Error())
+			continue
 		}
+		level, _ := ParseLevel(sc.Level)
+		format := sc.Format
+		if format != FormatText && format != FormatJSON {
+			format = config.Format
+		}
+		l.addSink(&sinkEntry{name: sc.Type, sink: s, level: level, format: format})
 	}
 }
 
+func (l *Logger) addSink(e *sinkEntry) {
+	sinkLock.Lock()
+	defer sinkLock.Unlock()
+	old := l.sinks.Load().([]*sinkEntry)
+	sinks := make([]*sinkEntry, 0, len(old)+1)
+	sinks = append(sinks, old...)
+	sinks = append(sinks, e)
+	l.sinks.Store(sinks)
+}
+
 func (l *Logger) run() {
 	if l.debug {
 		return
@@ -78,12 +108,33 @@ func (l *Logger) run() {
 	go l.writeLoop()
 }
 
+//stop 等待队列中已有的日志写完后关闭所有输出目标, 之后的日志直接打印到标准输出
 func (l *Logger) stop() {
-	if l != nil && l.w != nil {
-		l.w.Flush()
-		if l.f != nil {
-			l.f.Close()
-		}
+	if l == nil || l.debug || !atomic.CompareAndSwapInt32(&l.closed, 0, 1) {
+		return
+	}
+	close(l.quit)
+	l.sync(true)
+}
+
+//sync 通过队列发送同步请求, writeLoop 处理到该请求时之前的日志都已写入
+func (l *Logger) sync(close bool) {
+	if l == nil || l.debug {
+		return
+	}
+	m := &Msg{done: make(chan struct{}), close: close}
+	t := time.NewTimer(_SyncTimeout)
+	defer t.Stop()
+	select {
+	case l.ch <- m:
+	case <-t.C:
+		fmt.Fprintln(os.Stderr, "tlog: sync timeout, queue is full")
+		return
+	}
+	select {
+	case <-m.done:
+	case <-t.C:
+		fmt.Fprintln(os.Stderr, "tlog: sync timeout, writer is busy")
 	}
 }
 
@@ -91,59 +142,99 @@ func (l *Logger) writeLoop() {
 	for {
 		a := <-l.ch
 		if a == nil {
-			l.w.Flush()
-			fileInfo, err := os.Stat(l.fileName)
-			if err != nil && os.IsNotExist(err) {
-				l.f.Close()
-				l.f, _ = os.OpenFile(l.fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-				l.w.Reset(l.f)
+			for _, e := range l.sinks.Load().([]*sinkEntry) {
+				e.sink.Flush()
 			}
-			if fileInfo.Size() > l.fileSize {
-				l.f.Close()
-				os.Rename(l.fileName, l.logname())
-				l.f, _ = os.OpenFile(l.fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-				l.w.Reset(l.f)
-				l.rmOldFiles()
+			l.reportDropped()
+			l.sweepSamples()
+			continue
+		}
+		if a.done != nil {
+			l.reportDropped()
+			for _, e := range l.sinks.Load().([]*sinkEntry) {
+				e.sink.Flush()
+				if a.close {
+					e.sink.Close()
+				}
+			}
+			close(a.done)
+			if a.close {
+				close(l.exited)
+				return
 			}
 			continue
 		}
+		l.write(a)
+	}
+}
 
-		l.makeLog(a)
-		b := l.byteBuff.Bytes()
-		l.w.Write(b)
-		if l.useSyslog {
-			l.syslogW.Write(b)
+//write 按各输出目标的格式编码一次, 再写入级别符合的目标
+func (l *Logger) write(a *Msg) {
+	l.textBuff.Reset()
+	l.jsonBuff.Reset()
+	for _, e := range l.sinks.Load().([]*sinkEntry) {
+		if a.level < e.level {
+			continue
+		}
+		var err error
+		if rs, ok := e.sink.(RecordSink); ok {
+			err = rs.WriteRecord(&Record{
+				Time:   time.Now(),
+				Host:   l.host,
+				Level:  a.level,
+				File:   a.file,
+				Line:   a.line,
+				Msg:    strings.TrimPrefix(string(a.msg), " "),
+				Fields: a.fields,
+			})
+		} else {
+			w := &l.textBuff
+			if e.format == FormatJSON {
+				w = &l.jsonBuff
+			}
+			if w.Len() == 0 {
+				l.encode(a, e.format, w)
+			}
+			err = e.sink.Write(a.level, w.Bytes())
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tlog: write %s sink error: %s\n", e.name, err.Error())
 		}
-		l.byteBuff.Reset()
 	}
 }
 
 func (l *Logger) flushLoop() {
-	for range time.NewTicker(time.Second).C {
-		l.ch <- nil
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-l.quit:
+			return
+		case <-tick.C:
+			select {
+			case l.ch <- nil:
+			case <-l.quit:
+				return
+			}
+		}
 	}
 }
 
-func (l *Logger) logname() string {
-	t := fmt.Sprintf("%s", time.Now())[:19]
-	tt := strings.Replace(
-		strings.Replace(
-			strings.Replace(t, "-", "", -1),
-			" ", "", -1),
-		":", "", -1)
-	return fmt.Sprintf("%s.%s", l.fileName, tt)
-}
-
-func (l *Logger) p(level LEVEL, args ...interface{}) {
-	file, line := getFileNameAndLine()
-	if l == nil || l.debug {
+func (l *Logger) p(level LEVEL, fields []Field, args ...interface{}) {
+	file, line := getFileNameAndLine(2)
+	if l == nil || l.debug || atomic.LoadInt32(&l.closed) == 1 {
 		mu.Lock()
 		fmt.Printf("%s %s %s:%d ", genTime(), levelText[level], file, line)
-		fmt.Println(args...)
+		if len(fields) == 0 {
+			fmt.Println(args...)
+		} else {
+			fmt.Print(strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+			fmt.Println(textFields(fields))
+		}
 		mu.Unlock()
 		return
 	}
-	if level >= l.level {
+	if enabled(level, file) && l.sample(level, file, line) {
 		w := l.bytePool.Get().(*bytes.Buffer)
 		for _, arg := range args {
 			w.WriteByte(' ')
@@ -154,24 +245,26 @@ func (l *Logger) p(level LEVEL, args ...interface{}) {
 		w.Reset()
 		l.bytePool.Put(w)
 
-		select {
-		case l.ch <- &Msg{file: file, line: line, level: level, msg: b}:
-		default:
-		}
+		l.send(&Msg{file: file, line: line, level: level, msg: b, fields: fields})
 	}
 }
 
-func (l *Logger) pf(level LEVEL, format string, args ...interface{}) {
-	file, line := getFileNameAndLine()
-	if l == nil || l.debug {
+func (l *Logger) pf(level LEVEL, fields []Field, format string, args ...interface{}) {
+	file, line := getFileNameAndLine(2)
+	l.pfAt(file, line, level, fields, format, args...)
+}
+
+//pfAt 使用调用方给出的位置, 用于 CatchPanic 等无法按固定层数取调用位置的场景
+func (l *Logger) pfAt(file string, line int, level LEVEL, fields []Field, format string, args ...interface{}) {
+	if l == nil || l.debug || atomic.LoadInt32(&l.closed) == 1 {
 		mu.Lock()
 		fmt.Printf("%s %s %s:%d ", genTime(), levelText[level], file, line)
 		fmt.Printf(format, args...)
-		fmt.Println()
+		fmt.Println(textFields(fields))
 		mu.Unlock()
 		return
 	}
-	if level >= l.level {
+	if enabled(level, file) && l.sample(level, file, line) {
 		w := l.bytePool.Get().(*bytes.Buffer)
 		fmt.Fprintf(w, format, args...)
 		b := make([]byte, w.Len())
@@ -179,35 +272,41 @@ func (l *Logger) pf(level LEVEL, format string, args ...interface{}) {
 		w.Reset()
 		l.bytePool.Put(w)
 
-		select {
-		case l.ch <- &Msg{file: file, line: line, level: level, msg: b}:
-		default:
-		}
+		l.send(&Msg{file: file, line: line, level: level, msg: b, fields: fields})
 	}
 }
 
-func (l *Logger) makeLog(a *Msg) {
-	w := &l.byteBuff
+func (l *Logger) encode(a *Msg, format string, w *bytes.Buffer) {
+	if format == FormatJSON {
+		l.encodeJSON(a, w)
+		return
+	}
 	w.Write(genTime())
 	fmt.Fprintf(w, "%s %s %s:%d ", l.host, levelText[a.level], a.file, a.line)
 	w.Write(a.msg)
+	writeTextFields(w, a.fields)
 	w.WriteByte(10)
 }
 
-func (l *Logger) rmOldFiles() {
-	if out, err := exec.Command("ls", l.dir).Output(); err == nil {
-		files := bytes.Split(out, []byte("\n"))
-		totol, idx := len(files)-1, 0
-		for i := totol; i >= 0; i-- {
-			file := path.Join(l.dir, string(files[i]))
-			if strings.HasPrefix(file, l.fileName) && file != l.fileName {
-				idx++
-				if idx > l.fileNum {
-					os.Remove(file)
-				}
-			}
-		}
+//encodeJSON 每条日志一行 JSON, 字段与 time/host/level/file/msg 平级
+func (l *Logger) encodeJSON(a *Msg, w *bytes.Buffer) {
+	w.WriteString(`{"time":"`)
+	w.Write(genTime()[:19])
+	w.WriteString(`","host":`)
+	writeJSONString(w, l.host)
+	w.WriteString(`,"level":"`)
+	w.WriteString(levelText[a.level])
+	w.WriteString(`","file":`)
+	writeJSONString(w, a.file+":"+strconv.Itoa(a.line))
+	w.WriteString(`,"msg":`)
+	writeJSONString(w, strings.TrimPrefix(string(a.msg), " "))
+	for _, f := range a.fields {
+		w.WriteByte(',')
+		writeJSONString(w, f.Key)
+		w.WriteByte(':')
+		writeJSONValue(w, f.Value)
 	}
+	w.WriteString("}\n")
 }
 
 func genTime() []byte {
@@ -221,15 +320,21 @@ func genTime() []byte {
 		byte(second/10) + 48, byte(second%10) + 48, ' '}
 }
 
-func getFileNameAndLine() (string, int) {
-	_, file, line, ok := runtime.Caller(3)
+//getFileNameAndLine skip 为相对调用者的层数, p/pf 传 2 即为调用 tlog.Info 等函数的位置
+func getFileNameAndLine(skip int) (string, int) {
+	_, file, line, ok := runtime.Caller(skip + 1)
 	if !ok {
 		return "???", 0
 	}
+	return shortFile(file), line
+}
+
+//shortFile 只保留最后一级目录与文件名
+func shortFile(file string) string {
 	dirs := strings.Split(file, "/")
 	sz := len(dirs)
 	if sz >= 2 {
-		return dirs[sz-2] + "/" + dirs[sz-1], line
+		return dirs[sz-2] + "/" + dirs[sz-1]
 	}
-	return file, line
+	return file
 }
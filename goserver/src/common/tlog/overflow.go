@@ -0,0 +1,136 @@
+package tlog
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//日志队列满时的处理策略, ERROR/FATAL 在任何策略下都不会被丢弃
+const (
+	OverflowDropNewest = "drop_newest" //丢弃新日志(默认)
+	OverflowDropOldest = "drop_oldest" //丢弃队列中最旧的日志
+	OverflowBlock      = "block"       //堵塞等待, 超过 block_timeout 后丢弃
+	OverflowDropBelow  = "drop_below"  //低于 drop_below 级别的丢弃, 其余按 block 处理
+)
+
+//Stat 日志队列与丢弃计数
+type Stat struct {
+	Queue    int               `json:"queue"`
+	Capacity int               `json:"capacity"`
+	Dropped  uint64            `json:"dropped"`
+	Levels   map[string]uint64 `json:"levels"`
+}
+
+//Stats 返回日志队列长度与累计丢弃数量
+func Stats() Stat {
+	s := Stat{Levels: map[string]uint64{}}
+	if l == nil || l.ch == nil {
+		return s
+	}
+	s.Queue, s.Capacity = len(l.ch), cap(l.ch)
+	for lv := range l.dropped {
+		if n := atomic.LoadUint64(&l.dropped[lv]); n > 0 {
+			s.Levels[LEVEL(lv).String()] = n
+			s.Dropped += n
+		}
+	}
+	return s
+}
+
+//send 将日志放入队列, 队列已满时按 overflow 策略处理
+func (l *Logger) send(m *Msg) {
+	select {
+	case l.ch <- m:
+		return
+	default:
+	}
+
+	if m.level >= ERROR {
+		l.sendMust(m)
+		return
+	}
+	switch l.overflow {
+	case OverflowBlock:
+		l.sendTimeout(m)
+	case OverflowDropBelow:
+		if m.level < l.dropBelow {
+			l.drop(m.level)
+			return
+		}
+		l.sendTimeout(m)
+	case OverflowDropOldest:
+		select {
+		case old := <-l.ch:
+			if old == nil {
+				//定时刷新的信号, 丢弃不影响数据
+			} else if old.level >= ERROR || old.done != nil {
+				//队首是 ERROR/FATAL 或 Flush/Close 的同步请求则不能丢弃, 改为丢弃新日志;
+				//channel 无法放回队首, 放回后排到队尾, 位于之后入队的日志后面
+				l.sendMust(old)
+				l.drop(m.level)
+				return
+			} else {
+				l.drop(old.level)
+			}
+		default:
+		}
+		select {
+		case l.ch <- m:
+		default:
+			l.drop(m.level)
+		}
+	default:
+		l.drop(m.level)
+	}
+}
+
+//sendMust 堵塞等待入队, writeLoop 已退出(Close/Fatal 之后)时改为打印到标准输出, 不会永久堵塞
+func (l *Logger) sendMust(m *Msg) {
+	select {
+	case l.ch <- m:
+	case <-l.exited:
+		mu.Lock()
+		fmt.Printf("%s %s %s:%d %s%s\n", genTime(), levelText[m.level], m.file, m.line, m.msg, textFields(m.fields))
+		mu.Unlock()
+	}
+}
+
+func (l *Logger) sendTimeout(m *Msg) {
+	t := time.NewTimer(l.blockTimeout)
+	defer t.Stop()
+	select {
+	case l.ch <- m:
+	case <-t.C:
+		l.drop(m.level)
+	}
+}
+
+func (l *Logger) drop(level LEVEL) {
+	atomic.AddUint64(&l.dropped[level], 1)
+}
+
+//reportDropped 在 writeLoop 中周期性地把丢弃数量写入日志本身
+func (l *Logger) reportDropped() {
+	if time.Since(l.lastReport) < l.dropReport {
+		return
+	}
+	l.lastReport = time.Now()
+
+	var total uint64
+	var detail []string
+	for lv := range l.dropped {
+		n := atomic.LoadUint64(&l.dropped[lv])
+		if delta := n - l.reported[lv]; delta > 0 {
+			total += delta
+			detail = append(detail, fmt.Sprintf("%s=%d", LEVEL(lv).String(), delta))
+		}
+		l.reported[lv] = n
+	}
+	if total == 0 {
+		return
+	}
+	l.write(&Msg{file: "tlog/overflow.go", level: WARNING, msg: []byte(fmt.Sprintf(
+		"tlog queue overflow, dropped %d messages (%s), policy=%s", total, strings.Join(detail, " "), l.overflow))})
+}
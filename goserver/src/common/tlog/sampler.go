@@ -0,0 +1,121 @@
+package tlog
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+//SamplingConfig 按调用位置(文件:行)采样, 每个周期内先输出 first 条, 之后每 thereafter 条输出 1 条,
+//被抑制的数量在周期结束后以 "repeated N times" 汇总输出; FATAL 不参与采样
+type SamplingConfig struct {
+	Level      string `toml:"level" json:"level"`           //采样的日志级别, 每个级别单独配置
+	Interval   int    `toml:"interval" json:"interval"`     //周期秒数, 默认 1
+	First      int    `toml:"first" json:"first"`           //每个周期先输出的条数, 默认 100
+	Thereafter int    `toml:"thereafter" json:"thereafter"` //之后每多少条输出 1 条, 0 表示全部抑制
+}
+
+type sampler struct {
+	interval   int64
+	first      uint64
+	thereafter uint64
+}
+
+type siteKey struct {
+	file string
+	line int
+}
+
+type siteCounter struct {
+	sync.Mutex
+	level      LEVEL
+	window     int64
+	count      uint64
+	suppressed uint64
+}
+
+func newSamplers(configs []SamplingConfig) (samplers [FATAL + 1]*sampler) {
+	for _, c := range configs {
+		level, err := ParseLevel(c.Level)
+		if err != nil || level == FATAL {
+			continue
+		}
+		if c.Interval <= 0 {
+			c.Interval = 1
+		}
+		if c.First <= 0 {
+			c.First = 100
+		}
+		if c.Thereafter < 0 {
+			c.Thereafter = 0
+		}
+		samplers[level] = &sampler{
+			interval:   int64(c.Interval),
+			first:      uint64(c.First),
+			thereafter: uint64(c.Thereafter),
+		}
+	}
+	return
+}
+
+//sample 判断调用位置的本条日志是否输出
+func (l *Logger) sample(level LEVEL, file string, line int) bool {
+	s := l.samplers[level]
+	if s == nil {
+		return true
+	}
+	window := time.Now().Unix() / s.interval
+	key := siteKey{file: file, line: line}
+	v, ok := l.sites.Load(key)
+	if !ok {
+		v, _ = l.sites.LoadOrStore(key, &siteCounter{level: level, window: window})
+	}
+	c := v.(*siteCounter)
+
+	//汇总在解锁后发送, 避免队列满时持锁堵塞 writeLoop 的 sweepSamples
+	var summary *Msg
+	c.Lock()
+	if c.window != window {
+		if c.suppressed > 0 {
+			summary = l.summary(key, c, s)
+		}
+		c.window, c.count, c.suppressed = window, 0, 0
+	}
+	c.count++
+	pass := c.count <= s.first || (s.thereafter > 0 && (c.count-s.first)%s.thereafter == 0)
+	if !pass {
+		c.suppressed++
+	}
+	c.Unlock()
+
+	if summary != nil {
+		l.send(summary)
+	}
+	return pass
+}
+
+//sweepSamples 在 writeLoop 中周期性输出已结束周期的汇总, 并清理不再活跃的调用位置
+func (l *Logger) sweepSamples() {
+	now := time.Now().Unix()
+	l.sites.Range(func(k, v interface{}) bool {
+		key, c := k.(siteKey), v.(*siteCounter)
+		s := l.samplers[c.level]
+		window := now / s.interval
+		c.Lock()
+		if c.window != window {
+			if c.suppressed > 0 {
+				l.write(l.summary(key, c, s))
+				c.window, c.count, c.suppressed = window, 0, 0
+			} else {
+				l.sites.Delete(k)
+			}
+		}
+		c.Unlock()
+		return true
+	})
+}
+
+func (l *Logger) summary(key siteKey, c *siteCounter, s *sampler) *Msg {
+	return &Msg{file: key.file, line: key.line, level: c.level, msg: []byte(fmt.Sprintf(
+		"message repeated %d times in last %s, suppressed by sampling", c.suppressed, time.Duration(s.interval)*time.Second))}
+}
@@ -0,0 +1,250 @@
+package tlog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+//Sink 日志输出目标, 由 writeLoop 单协程调用, 实现无需加锁
+type Sink interface {
+	//Write 写入一条已编码的日志, b 在返回后会被复用
+	Write(level LEVEL, b []byte) error
+	//Flush 每秒调用一次
+	Flush() error
+	Close() error
+}
+
+//Record 未编码的一条日志
+type Record struct {
+	Time   time.Time
+	Host   string
+	Level  LEVEL
+	File   string
+	Line   int
+	Msg    string
+	Fields []Field
+}
+
+//RecordSink 需要按调用位置等信息处理日志的输出目标, 实现后 writeLoop 调用 WriteRecord 而不是 Write
+type RecordSink interface {
+	Sink
+	WriteRecord(r *Record) error
+}
+
+//SinkConfig 一个输出目标的配置, 在 toml 中以 [[Log.Sinks]] 声明
+type SinkConfig struct {
+	Type   string `toml:"type" json:"type"`     //file/stdout/stderr/syslog/tcp/udp 或 RegisterSink 注册的类型
+	Level  string `toml:"level" json:"level"`   //该输出的最低级别, 默认全部输出
+	Format string `toml:"format" json:"format"` //text/json, 默认与 Config.Format 相同
+
+	//file
+	Dir      string `toml:"dir" json:"dir"`
+	FileName string `toml:"filename" json:"filename"`
+	FileSize int    `toml:"filesize" json:"filesize"`
+	FileNum  int    `toml:"filenum" json:"filenum"`   //保留的历史文件数
+	Rotate   string `toml:"rotate" json:"rotate"`     //size(默认)/daily/hourly
+	Compress bool   `toml:"compress" json:"compress"` //切分后 gzip 压缩
+	MaxAge   int    `toml:"max_age" json:"max_age"`   //历史文件保留天数, 0 表示不限
+
+	//syslog
+	Tag string `toml:"tag" json:"tag"`
+
+	//tcp/udp
+	Addr string `toml:"addr" json:"addr"`
+}
+
+//SinkFactory 根据配置创建输出目标
+type SinkFactory func(c SinkConfig) (Sink, error)
+
+var (
+	sinkFactories = map[string]SinkFactory{
+		"file":   newFileSink,
+		"stdout": func(c SinkConfig) (Sink, error) { return newWriterSink(os.Stdout), nil },
+		"stderr": func(c SinkConfig) (Sink, error) { return newWriterSink(os.Stderr), nil },
+		"syslog": newSyslogSink,
+		"tcp":    newNetSink,
+		"udp":    newNetSink,
+	}
+	sinkLock sync.Mutex
+)
+
+//RegisterSink 注册自定义的输出类型, 需在 Init 之前调用
+func RegisterSink(typ string, f SinkFactory) {
+	sinkLock.Lock()
+	sinkFactories[typ] = f
+	sinkLock.Unlock()
+}
+
+func newSink(c SinkConfig) (Sink, error) {
+	sinkLock.Lock()
+	f, ok := sinkFactories[c.Type]
+	sinkLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown sink type: %s", c.Type)
+	}
+	return f(c)
+}
+
+//sinkEntry 输出目标及其级别与格式
+type sinkEntry struct {
+	name   string
+	sink   Sink
+	level  LEVEL
+	format string
+}
+
+//------------------------------------------------------------------
+//writerSink stdout/stderr, 适用于容器环境
+
+type writerSink struct {
+	w *bufio.Writer
+}
+
+func newWriterSink(w io.Writer) *writerSink {
+	return &writerSink{w: bufio.NewWriterSize(w, 64*1024)}
+}
+
+func (s *writerSink) Write(level LEVEL, b []byte) error {
+	_, err := s.w.Write(b)
+	return err
+}
+
+func (s *writerSink) Flush() error {
+	return s.w.Flush()
+}
+
+func (s *writerSink) Close() error {
+	return s.w.Flush()
+}
+
+//------------------------------------------------------------------
+//syslogSink 按日志级别映射 syslog 的优先级
+
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func newSyslogSink(c SinkConfig) (Sink, error) {
+	w, err := syslog.New(syslog.LOG_LOCAL3|syslog.LOG_INFO, c.Tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) Write(level LEVEL, b []byte) error {
+	m := string(b)
+	switch level {
+	case DEBUG, ALL:
+		return s.w.Debug(m)
+	case INFO:
+		return s.w.Info(m)
+	case WARNING:
+		return s.w.Warning(m)
+	case ERROR:
+		return s.w.Err(m)
+	default:
+		return s.w.Crit(m)
+	}
+}
+
+func (s *syslogSink) Flush() error {
+	return nil
+}
+
+func (s *syslogSink) Close() error {
+	return s.w.Close()
+}
+
+//------------------------------------------------------------------
+//netSink 发送到日志收集器, tcp 按行发送, udp 每条日志一个包;
+//连接失败时丢弃日志并在 _NetRetryInterval 后重连, 不堵塞 writeLoop
+
+const _NetRetryInterval = 5 * time.Second
+
+type netSink struct {
+	network string
+	addr    string
+	conn    net.Conn
+	w       *bufio.Writer
+	retryAt time.Time
+}
+
+func newNetSink(c SinkConfig) (Sink, error) {
+	if c.Addr == "" {
+		return nil, fmt.Errorf("%s sink: empty addr", c.Type)
+	}
+	s := &netSink{network: c.Type, addr: c.Addr}
+	s.connect()
+	return s, nil
+}
+
+func (s *netSink) connect() bool {
+	if s.conn != nil {
+		return true
+	}
+	if time.Now().Before(s.retryAt) {
+		return false
+	}
+	conn, err := net.DialTimeout(s.network, s.addr, time.Second)
+	if err != nil {
+		s.retryAt = time.Now().Add(_NetRetryInterval)
+		fmt.Fprintf(os.Stderr, "tlog: %s sink dial %s error: %s\n", s.network, s.addr, err.Error())
+		return false
+	}
+	s.conn = conn
+	if s.network == "tcp" {
+		s.w = bufio.NewWriterSize(conn, 64*1024)
+	}
+	return true
+}
+
+func (s *netSink) reset(err error) error {
+	fmt.Fprintf(os.Stderr, "tlog: %s sink write %s error: %s\n", s.network, s.addr, err.Error())
+	s.conn.Close()
+	s.conn, s.w = nil, nil
+	s.retryAt = time.Now().Add(_NetRetryInterval)
+	return err
+}
+
+func (s *netSink) Write(level LEVEL, b []byte) error {
+	if !s.connect() {
+		return nil
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	var err error
+	if s.w != nil {
+		_, err = s.w.Write(b)
+	} else {
+		_, err = s.conn.Write(b)
+	}
+	if err != nil {
+		return s.reset(err)
+	}
+	return nil
+}
+
+func (s *netSink) Flush() error {
+	if s.conn == nil || s.w == nil {
+		return nil
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	if err := s.w.Flush(); err != nil {
+		return s.reset(err)
+	}
+	return nil
+}
+
+func (s *netSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	s.Flush()
+	return s.conn.Close()
+}
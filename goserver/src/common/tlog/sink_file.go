@@ -0,0 +1,198 @@
+package tlog
+
+import (
+	"bufio"
+	"common/fileutil"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+//文件切分方式, 按时间切分时超过 filesize 同样会切分
+const (
+	RotateSize   = "size"
+	RotateDaily  = "daily"
+	RotateHourly = "hourly"
+)
+
+//fileSink 写入本地文件, 按大小或时间切分; 切分后的文件在后台压缩和清理
+type fileSink struct {
+	fileSize int64
+	fileNum  int
+	maxAge   time.Duration
+	rotate   string
+	compress bool
+	fileName string
+	dir      string
+	period   string //当前文件所属的时间段, 按时间切分时使用
+	f        *os.File
+	w        *bufio.Writer
+	rotated  chan string
+}
+
+func newFileSink(c SinkConfig) (Sink, error) {
+	if c.Dir == "" {
+		c.Dir = "./logs"
+	}
+	if c.FileName == "" {
+		c.FileName = "INFO"
+	}
+	if c.FileSize == 0 {
+		c.FileSize = 128
+	}
+	if c.FileNum == 0 {
+		c.FileNum = 10
+	}
+	switch c.Rotate {
+	case RotateDaily, RotateHourly:
+	default:
+		c.Rotate = RotateSize
+	}
+	s := &fileSink{
+		dir:      c.Dir,
+		fileSize: int64(c.FileSize * 1024 * 1024),
+		fileNum:  c.FileNum,
+		maxAge:   time.Duration(c.MaxAge) * 24 * time.Hour,
+		rotate:   c.Rotate,
+		compress: c.Compress,
+		fileName: path.Join(c.Dir, c.FileName+".log"),
+		rotated:  make(chan string, 16),
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(s.fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.f = f
+	s.w = bufio.NewWriterSize(s.f, 1024*1024)
+	//已有内容的文件沿用其最后修改时间所在的时间段, 避免重启后跨天的日志留在当前文件
+	s.period = s.periodOf(time.Now())
+	if fi, err := f.Stat(); err == nil && fi.Size() > 0 {
+		s.period = s.periodOf(fi.ModTime())
+	}
+	go s.archiveLoop(s.rotated)
+	return s, nil
+}
+
+func (s *fileSink) Write(level LEVEL, b []byte) error {
+	_, err := s.w.Write(b)
+	return err
+}
+
+//Flush 刷盘, 并检查文件是否被删除或需要切分
+func (s *fileSink) Flush() error {
+	s.w.Flush()
+	fileInfo, err := os.Stat(s.fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.reopen()
+		}
+		return err
+	}
+	period := s.periodOf(time.Now())
+	if fileInfo.Size() > s.fileSize || (period != s.period && fileInfo.Size() > 0) {
+		s.f.Close()
+		name := s.logname()
+		if err = os.Rename(s.fileName, name); err == nil {
+			select {
+			case s.rotated <- name:
+			default:
+				//积压时跳过压缩, 下次切分时仍会按数量与时间清理
+				fmt.Fprintf(os.Stderr, "tlog: archive queue full, skip compressing %s\n", name)
+			}
+		}
+		s.reopen()
+	}
+	s.period = period
+	return err
+}
+
+//Close 刷盘并结束后台的压缩协程, 已在队列中的文件仍会被处理
+func (s *fileSink) Close() error {
+	s.w.Flush()
+	if s.rotated != nil {
+		close(s.rotated)
+		s.rotated = nil
+	}
+	return s.f.Close()
+}
+
+func (s *fileSink) reopen() {
+	s.f.Close()
+	s.f, _ = os.OpenFile(s.fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	s.w.Reset(s.f)
+}
+
+func (s *fileSink) periodOf(t time.Time) string {
+	switch s.rotate {
+	case RotateDaily:
+		return t.Format("20060102")
+	case RotateHourly:
+		return t.Format("2006010215")
+	default:
+		return ""
+	}
+}
+
+//logname 按大小切分时为 {filename}.log.{yyyymmddhhmmss}, 按时间切分时为文件所属的时间段
+//{filename}.log.{yyyymmdd} 或 {filename}.log.{yyyymmddhh}, 重名时追加序号
+func (s *fileSink) logname() string {
+	suffix := s.period
+	if suffix == "" {
+		suffix = time.Now().Format("20060102150405")
+	}
+	name := s.fileName + "." + suffix
+	for i := 1; ; i++ {
+		if !fileutil.Exists(name) && !fileutil.Exists(name+".gz") {
+			return name
+		}
+		name = fmt.Sprintf("%s.%s.%d", s.fileName, suffix, i)
+	}
+}
+
+//archiveLoop 在后台压缩切分出的文件并清理过期文件, 不堵塞 writeLoop
+func (s *fileSink) archiveLoop(rotated chan string) {
+	for name := range rotated {
+		if s.compress {
+			if err := fileutil.Gzip(name); err != nil {
+				fmt.Fprintf(os.Stderr, "tlog: compress %s error: %s\n", name, err.Error())
+			}
+		}
+		s.rmOldFiles()
+	}
+}
+
+//rmOldFiles 按修改时间从新到旧, 保留 fileNum 个且不超过 maxAge 的历史文件
+func (s *fileSink) rmOldFiles() {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	base := path.Base(s.fileName) + "."
+	var files []os.FileInfo
+	for _, fi := range infos {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasPrefix(name, base) || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		files = append(files, fi)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].ModTime().Equal(files[j].ModTime()) {
+			return files[i].Name() > files[j].Name()
+		}
+		return files[i].ModTime().After(files[j].ModTime())
+	})
+	for i, fi := range files {
+		expired := s.maxAge > 0 && time.Since(fi.ModTime()) > s.maxAge
+		if (s.fileNum > 0 && i >= s.fileNum) || expired {
+			os.Remove(path.Join(s.dir, fi.Name()))
+		}
+	}
+}
@@ -1,5 +1,16 @@
 package tlog
 
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
 type Config struct {
 	FileSize  int    `toml:"filesize" json:"filesize"`
 	FileNum   int    `toml:"filenum" json:"filenum"`
@@ -9,6 +20,42 @@ type Config struct {
 	Dir       string `toml:"dir" json:"dir"`
 	UseSyslog bool   `toml:"use_syslog" json:"use_syslog"`
 	SyslogTag string `toml:"syslog_tag" json:"syslog_tag"`
+	Format    string `toml:"format" json:"format"`     //text(默认) 或 json
+	Rotate    string `toml:"rotate" json:"rotate"`     //size(默认)/daily/hourly
+	Compress  bool   `toml:"compress" json:"compress"` //切分后 gzip 压缩
+	MaxAge    int    `toml:"max_age" json:"max_age"`   //历史文件保留天数, 0 表示不限
+
+	//队列满时的策略: drop_newest(默认)/drop_oldest/block/drop_below
+	Overflow     string `toml:"overflow" json:"overflow"`
+	BlockTimeout int    `toml:"block_timeout" json:"block_timeout"` //block 策略的等待毫秒数, 默认 100
+	DropBelow    string `toml:"drop_below" json:"drop_below"`       //drop_below 策略的级别, 默认 WARNING
+	DropReport   int    `toml:"drop_report" json:"drop_report"`     //丢弃统计写入日志的间隔秒数, 默认 60
+
+	//输出目标列表, 为空时使用上面的 file 与 syslog 配置
+	Sinks []SinkConfig `toml:"Sinks" json:"sinks"`
+	//按级别配置的采样, 用于抑制循环中大量重复的日志
+	Sampling []SamplingConfig `toml:"Sampling" json:"sampling"`
+}
+
+//sinkConfigs 未配置 Sinks 时, 兼容原有的单文件加可选 syslog 的输出方式
+func (c *Config) sinkConfigs() []SinkConfig {
+	if len(c.Sinks) > 0 {
+		return c.Sinks
+	}
+	sinks := []SinkConfig{{
+		Type:     "file",
+		Dir:      c.Dir,
+		FileName: c.FileName,
+		FileSize: c.FileSize,
+		FileNum:  c.FileNum,
+		Rotate:   c.Rotate,
+		Compress: c.Compress,
+		MaxAge:   c.MaxAge,
+	}}
+	if c.UseSyslog {
+		sinks = append(sinks, SinkConfig{Type: "syslog", Tag: c.SyslogTag})
+	}
+	return sinks
 }
 
 func (c *Config) check() {
@@ -27,54 +74,102 @@ func (c *Config) check() {
 	if c.Level == "" {
 		c.Level = "DEBUG"
 	}
+	if c.Format != FormatJSON {
+		c.Format = FormatText
+	}
+	switch c.Overflow {
+	case OverflowDropOldest, OverflowBlock, OverflowDropBelow:
+	default:
+		c.Overflow = OverflowDropNewest
+	}
+	if c.BlockTimeout <= 0 {
+		c.BlockTimeout = 100
+	}
+	if _, err := ParseLevel(c.DropBelow); err != nil || c.DropBelow == "" {
+		c.DropBelow = "WARNING"
+	}
+	if c.DropReport <= 0 {
+		c.DropReport = 60
+	}
 }
 
 func Init(c Config) {
 	c.check()
+	level, err := ParseLevel(c.Level)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tlog: %s: %q, use DEBUG\n", err.Error(), c.Level)
+		level = DEBUG
+	}
+	atomic.StoreInt32(&baseLevel, int32(level))
+	atomic.StoreInt32(&curLevel, int32(level))
 	newLogger(c)
 	l.run()
+	watchSignal()
 }
 
+//Close 同步写完队列中的日志并关闭所有输出目标
 func Close() {
 	l.stop()
 }
 
+//Flush 同步写完队列中的日志并刷新所有输出目标
+func Flush() {
+	if l != nil && atomic.LoadInt32(&l.closed) == 0 {
+		l.sync(false)
+	}
+}
+
+// AddSink 运行期增加一个输出目标, 仅输出 level 及以上的日志, format 为空则使用 Config.Format
+func AddSink(name string, s Sink, level LEVEL, format string) {
+	if l == nil || l.debug {
+		return
+	}
+	if format != FormatText && format != FormatJSON {
+		format = l.format
+	}
+	l.addSink(&sinkEntry{name: name, sink: s, level: level, format: format})
+}
+
 func Debug(args ...interface{}) {
-	l.p(DEBUG, args...)
+	l.p(DEBUG, nil, args...)
 }
 
 func Debugf(format string, args ...interface{}) {
-	l.pf(DEBUG, format, args...)
+	l.pf(DEBUG, nil, format, args...)
 }
 
 func Info(args ...interface{}) {
-	l.p(INFO, args...)
+	l.p(INFO, nil, args...)
 }
 
 func Infof(format string, args ...interface{}) {
-	l.pf(INFO, format, args...)
+	l.pf(INFO, nil, format, args...)
 }
 
 func Warning(args ...interface{}) {
-	l.p(WARNING, args...)
+	l.p(WARNING, nil, args...)
 }
 
 func Warningf(format string, args ...interface{}) {
-	l.pf(WARNING, format, args...)
+	l.pf(WARNING, nil, format, args...)
 }
 
 func Error(args ...interface{}) {
-	l.p(ERROR, args...)
+	l.p(ERROR, nil, args...)
 }
 
 func Errorf(format string, args ...interface{}) {
-	l.pf(ERROR, format, args...)
+	l.pf(ERROR, nil, format, args...)
 }
 
+//Fatal 记录日志, 写完所有输出目标后退出进程
 func Fatal(args ...interface{}) {
-	l.p(FATAL, args...)
+	l.p(FATAL, nil, args...)
+	fatalExit()
 }
 
+//Fatalf 记录日志, 写完所有输出目标后退出进程
 func Fatalf(format string, args ...interface{}) {
-	l.pf(FATAL, format, args...)
+	l.pf(FATAL, nil, format, args...)
+	fatalExit()
 }
@@ -1,6 +1,14 @@
 package tlog
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -35,3 +43,237 @@ func doLog() {
 	Error(b)
 	Errorf("%v", b)
 }
+
+func TestFormat(t *testing.T) {
+	fields := []Field{String("uid", "1001"), Duration("latency", 1500*time.Microsecond), Err(errors.New("bad request")), Any("ids", []int{1, 2})}
+	lg := &Logger{host: "test"}
+	var buf bytes.Buffer
+	lg.encode(&Msg{file: "tlog/tlog_test.go", line: 1, level: INFO, msg: []byte(" hello"), fields: fields}, FormatJSON, &buf)
+	line := buf.Bytes()
+	if !json.Valid(line) {
+		t.Fatalf("invalid json line: %s", line)
+	}
+	var m map[string]interface{}
+	json.Unmarshal(line, &m)
+	if m["msg"] != "hello" || m["uid"] != "1001" || m["latency"] != 1.5 || m["error"] != "bad request" {
+		t.Fatalf("unexpected json line: %s", line)
+	}
+
+	buf.Reset()
+	lg.encode(&Msg{file: "tlog/tlog_test.go", line: 1, level: INFO, msg: []byte("hello"), fields: fields}, FormatText, &buf)
+	if !strings.HasSuffix(buf.String(), `hello uid=1001 latency=1.5 error="bad request" ids="[1 2]"`+"\n") {
+		t.Fatalf("unexpected text line: %s", buf.String())
+	}
+}
+
+func TestLevel(t *testing.T) {
+	if _, err := ParseLevel("verbose"); err != ErrInvalidLevel {
+		t.Fatal("expect invalid level error")
+	}
+	if err := SetLevel("warn"); err != nil || GetLevel() != "WARNING" {
+		t.Fatalf("set level: %v %s", err, GetLevel())
+	}
+	SetPackageLevel("discovery/", "DEBUG")
+	SetPackageLevel("discovery/balancer.go", "ERROR")
+	defer SetPackageLevel("discovery/", "")
+	defer SetPackageLevel("discovery/balancer.go", "")
+
+	if !enabled(DEBUG, "discovery/discovery.go") || enabled(WARNING, "discovery/balancer.go") || enabled(INFO, "util/redis.go") {
+		t.Fatalf("unexpected levels: %v", Levels())
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	lg := &Logger{ch: make(chan *Msg, 2), overflow: OverflowDropOldest}
+	lg.send(&Msg{level: ERROR})
+	lg.send(&Msg{level: INFO})
+	lg.send(&Msg{level: INFO}) //队首是 ERROR, 放回队尾并丢弃新日志
+	if a, b := <-lg.ch, <-lg.ch; a.level != INFO || b.level != ERROR {
+		t.Fatalf("expect INFO and ERROR, got %s and %s", a.level, b.level)
+	}
+	lg.send(&Msg{level: INFO})
+	lg.send(&Msg{level: INFO})
+	lg.send(&Msg{level: DEBUG}) //丢弃最旧的 INFO
+	if a, b := <-lg.ch, <-lg.ch; a.level != INFO || b.level != DEBUG {
+		t.Fatalf("expect INFO and DEBUG, got %s and %s", a.level, b.level)
+	}
+	if lg.dropped[INFO] != 2 {
+		t.Fatalf("expect 2 dropped INFO, got %d", lg.dropped[INFO])
+	}
+
+	//队首是同步请求时不丢弃, 否则 Flush/Close 会等到超时
+	req := &Msg{done: make(chan struct{}), close: true}
+	lg.send(req)
+	lg.send(&Msg{level: INFO})
+	lg.send(&Msg{level: INFO})
+	if a, b := <-lg.ch, <-lg.ch; a.level != INFO || b != req {
+		t.Fatalf("sync request lost, got %+v and %+v", a, b)
+	}
+	if lg.dropped[INFO] != 3 {
+		t.Fatalf("expect 3 dropped INFO, got %d", lg.dropped[INFO])
+	}
+
+	lg = &Logger{ch: make(chan *Msg, 1), overflow: OverflowDropBelow, dropBelow: WARNING, blockTimeout: time.Millisecond}
+	lg.send(&Msg{level: INFO})
+	lg.send(&Msg{level: INFO})
+	lg.send(&Msg{level: WARNING})
+	if lg.dropped[INFO] != 1 || lg.dropped[WARNING] != 1 {
+		t.Fatalf("unexpected dropped: %v", lg.dropped)
+	}
+
+	//writeLoop 已退出时 ERROR 不会永久堵塞
+	lg = &Logger{ch: make(chan *Msg, 1), exited: make(chan struct{})}
+	lg.send(&Msg{level: INFO})
+	close(lg.exited)
+	done := make(chan struct{})
+	go func() {
+		lg.send(&Msg{level: ERROR, msg: []byte(" after close")})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ERROR blocked after writer exited")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink, err := newFileSink(SinkConfig{Dir: dir, FileName: "run", FileNum: 2, Rotate: RotateHourly, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := sink.(*fileSink)
+	for i := 0; i < 3; i++ {
+		s.Write(INFO, []byte("hello\n"))
+		s.period = "2020010100"
+		s.Flush()
+	}
+	s.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "run.log.*.gz"))
+	if len(files) != 2 {
+		t.Fatalf("expect 2 compressed files, got %v", files)
+	}
+	//按切分前文件所属的时间段命名, 而不是切分时的时间
+	for _, f := range files {
+		if !strings.HasPrefix(filepath.Base(f), "run.log.2020010100.") {
+			t.Fatalf("unexpected archive name %s", f)
+		}
+	}
+}
+
+func TestSampling(t *testing.T) {
+	lg := &Logger{ch: make(chan *Msg, 10), samplers: newSamplers([]SamplingConfig{{Level: "ERROR", Interval: 60, First: 2, Thereafter: 3}})}
+	passed := 0
+	for i := 0; i < 10; i++ {
+		if lg.sample(ERROR, "util/redis.go", 100) {
+			passed++
+		}
+	}
+	//前 2 条, 之后第 3/6 条
+	if passed != 4 || !lg.sample(INFO, "util/redis.go", 100) {
+		t.Fatalf("expect 4 sampled, got %d", passed)
+	}
+	v, _ := lg.sites.Load(siteKey{file: "util/redis.go", line: 100})
+	if c := v.(*siteCounter); c.suppressed != 6 {
+		t.Fatalf("expect 6 suppressed, got %d", c.suppressed)
+	}
+}
+
+//memSink 记录写入的日志以及是否已关闭
+type memSink struct {
+	sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (this *memSink) Write(level LEVEL, b []byte) error {
+	this.Lock()
+	this.buf.Write(b)
+	this.Unlock()
+	return nil
+}
+
+func (this *memSink) Flush() error {
+	return nil
+}
+
+func (this *memSink) Close() error {
+	this.Lock()
+	this.closed = true
+	this.Unlock()
+	return nil
+}
+
+func (this *memSink) String() string {
+	this.Lock()
+	defer this.Unlock()
+	return this.buf.String()
+}
+
+func initMemSink() *memSink {
+	s := &memSink{}
+	RegisterSink("mem", func(c SinkConfig) (Sink, error) { return s, nil })
+	Init(Config{Level: "DEBUG", Sinks: []SinkConfig{{Type: "mem"}}})
+	return s
+}
+
+func TestFatal(t *testing.T) {
+	s := initMemSink()
+	code := -1
+	closed := false
+	exitFunc = func(c int) {
+		code = c
+		s.Lock()
+		closed = s.closed
+		s.Unlock()
+	}
+	defer func() { exitFunc = os.Exit }()
+
+	ErrorWithStack("stack-a")
+	ErrorfWithStack("stack-%s", "b")
+	Fatal("fatal error")
+	//退出前所有输出目标已写完并关闭
+	if code != 1 || !closed {
+		t.Fatalf("exit code %d, sink closed %v", code, closed)
+	}
+	out := s.String()
+	for _, want := range []string{"ERROR tlog/tlog_test.go:", "stack-a", "stack-b", "FATAL tlog/tlog_test.go:", "fatal error"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "runtime/debug.Stack"); n != 2 {
+		t.Errorf("expect 2 stacks, got %d:\n%s", n, out)
+	}
+}
+
+func TestCatchPanic(t *testing.T) {
+	s := initMemSink()
+	defer Close()
+
+	func() {
+		defer func() {
+			//记录日志后继续 panic
+			if r := recover(); r != "boom" {
+				t.Fatalf("expect panic again, got %v", r)
+			}
+		}()
+		defer CatchPanic()
+		panic("boom")
+	}()
+	out := s.String()
+	//调用位置为触发 panic 的函数, 而不是 runtime/panic.go
+	for _, want := range []string{"FATAL tlog/tlog_test.go:", "panic: boom", "goroutine "} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
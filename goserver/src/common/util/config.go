@@ -1,21 +1,362 @@
 package util
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/BurntSushi/toml"
+	"github.com/go-playground/validator/v10"
 )
 
+//NewConfig 加载配置, 文件由全局的 -config 参数指定, 不解析其他参数, 失败时打印所有错误并返回 false
 func NewConfig(defaultPath string, v interface{}) bool {
-	confName := flag.String("config", defaultPath, "config file")
-	flag.Parse()
-
-	fmt.Printf("Load config file: %s\n", *confName)
-	_, err := toml.DecodeFile(*confName, v)
-	if err != nil {
-		fmt.Println("config file load failed:", *confName, err)
+	if flag.Lookup("config") == nil {
+		flag.String("config", defaultPath, "config file")
+	}
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	loader := &ConfigLoader{Path: flag.Lookup("config").Value.String(), Args: []string{}}
+	if err := loader.Load(v); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Printf("config file load failed: %s\n%s\n", loader.Path, err.Error())
+		}
 		return false
 	}
 	return true
 }
+
+//ConfigLoader 分层加载 toml 配置, 后面的覆盖前面的:
+//1. 基础文件 Path, 可由 -config 指定
+//2. 环境文件, 与基础文件同目录, 文件名最后一个 - 之后替换为 env, eg. api-dev.toml 与 prod 对应 api-prod.toml,
+//   env 由 -env 或环境变量 {EnvPrefix}CONFIG_ENV 指定, 指定后必须存在, 与基础文件相同时跳过
+//3. 环境变量 {EnvPrefix}{KEY}, KEY 为 toml 路径大写并以 _ 连接, eg. API_DB_PWD 覆盖 [Db] pwd, EnvPrefix 为空时不读取
+//4. 命令行 -set Db.pwd=xxx, 可重复
+//然后解析密钥引用, 见 ResolveConfigSecrets, 最后按 validate 标签(github.com/go-playground/validator)校验, 所有错误一次返回
+type ConfigLoader struct {
+	Path      string
+	Env       string
+	EnvPrefix string
+	Args      []string //为 nil 时使用 os.Args[1:], 不使用全局的 flag.CommandLine
+}
+
+//ConfigErrors 加载或校验配置时的所有错误
+type ConfigErrors []string
+
+func (this ConfigErrors) Error() string {
+	return strings.Join(this, "\n")
+}
+
+type setFlags []string
+
+func (this *setFlags) String() string {
+	return strings.Join(*this, ",")
+}
+
+func (this *setFlags) Set(s string) error {
+	*this = append(*this, s)
+	return nil
+}
+
+//Load 加载并校验, 成功后 Path 与 Env 为实际使用的值
+func (this *ConfigLoader) Load(v interface{}) error {
+	args := this.Args
+	if args == nil {
+		args = os.Args[1:]
+	}
+	env := this.Env
+	if env == "" && this.EnvPrefix != "" {
+		env = os.Getenv(this.EnvPrefix + "CONFIG_ENV")
+	}
+	var sets setFlags
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	fs.StringVar(&this.Path, "config", this.Path, "config file")
+	fs.StringVar(&this.Env, "env", env, "env file overrides config file, eg. -env prod loads api-prod.toml over api-dev.toml")
+	fs.Var(&sets, "set", "override a config key, eg. -set Db.pwd=xxx, repeatable")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	fmt.Printf("Load config file: %s\n", this.Path)
+	table := map[string]interface{}{}
+	if _, err := toml.DecodeFile(this.Path, &table); err != nil {
+		return err
+	}
+	if envPath := envFile(this.Path, this.Env); envPath != "" {
+		fmt.Printf("Load config file: %s\n", envPath)
+		overlay := map[string]interface{}{}
+		if _, err := toml.DecodeFile(envPath, &overlay); err != nil {
+			return err
+		}
+		mergeTable(table, overlay)
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(table); err != nil {
+		return err
+	}
+	if _, err := toml.Decode(buf.String(), v); err != nil {
+		return err
+	}
+
+	var errs ConfigErrors
+	fields := configFields(reflect.ValueOf(v), nil)
+	if this.EnvPrefix != "" {
+		for _, f := range fields {
+			name := this.EnvPrefix + f.envName()
+			if s, ok := os.LookupEnv(name); ok {
+				if err := setConfigValue(f.v, s); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: %s", name, err.Error()))
+				}
+			}
+		}
+	}
+	for _, s := range sets {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 {
+			errs = append(errs, fmt.Sprintf("-set %s: expect key=value", s))
+			continue
+		}
+		found := false
+		for _, f := range fields {
+			if strings.EqualFold(f.key(), strings.TrimSpace(kv[0])) {
+				found = true
+				if err := setConfigValue(f.v, kv[1]); err != nil {
+					errs = append(errs, fmt.Sprintf("-set %s: %s", kv[0], err.Error()))
+				}
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("-set %s: unknown key", kv[0]))
+		}
+	}
+
+	errs = append(errs, ResolveConfigSecrets(v)...)
+	errs = append(errs, ValidateConfig(v)...)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+//envFile 环境文件的路径, 基础文件名没有 - 时追加 -{env}, 未指定 env 或与基础文件相同时返回空
+func envFile(path string, env string) string {
+	if env == "" {
+		return ""
+	}
+	ext := filepath.Ext(path)
+	name := strings.TrimSuffix(filepath.Base(path), ext)
+	if i := strings.LastIndex(name, "-"); i >= 0 {
+		name = name[:i]
+	}
+	envPath := filepath.Join(filepath.Dir(path), name+"-"+env+ext)
+	if filepath.Clean(envPath) == filepath.Clean(path) {
+		return ""
+	}
+	return envPath
+}
+
+//mergeTable 子表递归合并, 其他值(包括数组)整体替换
+func mergeTable(dst, src map[string]interface{}) {
+	for k, sv := range src {
+		if st, ok := sv.(map[string]interface{}); ok {
+			if dt, ok := dst[k].(map[string]interface{}); ok {
+				mergeTable(dt, st)
+				continue
+			}
+		}
+		dst[k] = sv
+	}
+}
+
+//configField 可被环境变量与 -set 覆盖的配置项
+type configField struct {
+	path []string
+	v    reflect.Value
+}
+
+func (this configField) key() string {
+	return strings.Join(this.path, ".")
+}
+
+func (this configField) envName() string {
+	return strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(strings.Join(this.path, "_")))
+}
+
+//configFields 基本类型与基本类型的切片, 路径取 toml 标签, 匿名嵌入的结构体展开到当前层
+func configFields(v reflect.Value, path []string) []configField {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	var fields []configField
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("toml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		fv := v.Field(i)
+		if name == "" && f.Anonymous {
+			fields = append(fields, configFields(fv, path)...)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		p := append(append([]string{}, path...), name)
+		switch fv.Kind() {
+		case reflect.Struct, reflect.Ptr:
+			fields = append(fields, configFields(fv, p)...)
+		case reflect.Slice:
+			if isScalar(fv.Type().Elem().Kind()) {
+				fields = append(fields, configField{path: p, v: fv})
+			}
+		default:
+			if isScalar(fv.Kind()) {
+				fields = append(fields, configField{path: p, v: fv})
+			}
+		}
+	}
+	return fields
+}
+
+func isScalar(k reflect.Kind) bool {
+	switch k {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+//setConfigValue 切片以逗号分隔
+func setConfigValue(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Slice {
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		sv := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setConfigValue(sv.Index(i), item); err != nil {
+				return err
+			}
+		}
+		v.Set(sv)
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type().String())
+	}
+	return nil
+}
+
+var configValidator = validator.New()
+
+//ValidateConfig 按 validate 标签校验, eg. validate:"required" 或 validate:"min=1,max=1000", 返回所有不通过的字段
+func ValidateConfig(v interface{}) ConfigErrors {
+	err := configValidator.Struct(v)
+	if err == nil {
+		return nil
+	}
+	ves, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return ConfigErrors{err.Error()}
+	}
+	t := reflect.TypeOf(v)
+	errs := make(ConfigErrors, 0, len(ves))
+	for _, fe := range ves {
+		key := tomlKey(t, fe.StructNamespace())
+		rule := fe.Tag()
+		if fe.Param() != "" {
+			rule += "=" + fe.Param()
+		}
+		value := fe.Value()
+		if s, ok := value.(string); ok && isSecret(s) || IsSensitiveName(key) {
+			value = MaskedValue
+		}
+		errs = append(errs, fmt.Sprintf("%s: failed on '%s', got %v", key, rule, value))
+	}
+	return errs
+}
+
+//tomlKey 将 Config.Db.Addr 形式的字段路径转换为 toml 中的 Db.addr, 匿名嵌入的结构体不出现在路径中
+func tomlKey(t reflect.Type, ns string) string {
+	parts := strings.Split(ns, ".")[1:]
+	keys := make([]string, 0, len(parts))
+	for _, part := range parts {
+		name, index := part, ""
+		if i := strings.Index(part, "["); i >= 0 {
+			name, index = part[:i], part[i:]
+		}
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			keys = append(keys, part)
+			continue
+		}
+		f, ok := t.FieldByName(name)
+		if !ok {
+			keys = append(keys, part)
+			continue
+		}
+		t = f.Type
+		tag := strings.Split(f.Tag.Get("toml"), ",")[0]
+		if tag == "" && f.Anonymous {
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		keys = append(keys, tag+index)
+	}
+	return strings.Join(keys, ".")
+}
@@ -0,0 +1,196 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testConfig struct {
+	testBase
+	Host  string      `toml:"server_host" validate:"required"`
+	Level int         `toml:"level" validate:"min=1,max=5"`
+	Db    MysqlConfig `toml:"Db"`
+	Redis RedisConfig `toml:"Redis"`
+}
+
+type testBase struct {
+	Env string `toml:"env" validate:"required"`
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigLoader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	base := filepath.Join(dir, "api-dev.toml")
+	writeFile(t, base, `
+env = "dev"
+server_host = "0.0.0.0:8801"
+level = 1
+[Db]
+addr = "127.0.0.1:3306"
+user = "root"
+pwd = "file"
+dbname = "test"
+max_open_conns = 20
+[Redis]
+addrs = ["127.0.0.1:6379"]
+`)
+	writeFile(t, filepath.Join(dir, "api-prod.toml"), `
+env = "prod"
+[Db]
+addr = "10.0.0.1:3306"
+`)
+
+	os.Setenv("TEST_CONFIG_ENV", "prod")
+	os.Setenv("TEST_DB_PWD", "from-env")
+	os.Setenv("TEST_REDIS_ADDRS", "10.0.0.2:6379, 10.0.0.3:6379")
+	defer os.Unsetenv("TEST_CONFIG_ENV")
+	defer os.Unsetenv("TEST_DB_PWD")
+	defer os.Unsetenv("TEST_REDIS_ADDRS")
+
+	var c testConfig
+	loader := &ConfigLoader{
+		Path:      base,
+		EnvPrefix: "TEST_",
+		Args:      []string{"-set", "db.max_open_conns=50", "-set", "level=3"},
+	}
+	if err := loader.Load(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Env != "prod" || c.Host != "0.0.0.0:8801" || c.Level != 3 {
+		t.Fatalf("unexpected config %+v", c)
+	}
+	if c.Db.Addr != "10.0.0.1:3306" || c.Db.User != "root" || c.Db.Pwd != "from-env" || c.Db.MaxOpenConns != 50 {
+		t.Fatalf("unexpected db %+v", c.Db)
+	}
+	if len(c.Redis.Addrs) != 2 || c.Redis.Addrs[1] != "10.0.0.3:6379" {
+		t.Fatalf("unexpected redis %+v", c.Redis)
+	}
+
+	//所有错误一次返回
+	writeFile(t, base, `
+level = 9
+[Db]
+addr = "127.0.0.1:3306"
+pwd = "secret"
+max_open_conns = -1
+`)
+	c = testConfig{}
+	loader = &ConfigLoader{Path: base, Args: []string{"-set", "unknown=1", "-set", "db.max_idle_conns=x"}}
+	err = loader.Load(&c)
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+	want := []string{
+		"-set unknown: unknown key",
+		"-set db.max_idle_conns: ",
+		"env: failed on 'required'",
+		"server_host: failed on 'required'",
+		"level: failed on 'max=5', got 9",
+		"Db.user: failed on 'required'",
+		"Db.dbname: failed on 'required'",
+		"Db.max_open_conns: failed on 'gte=0', got -1",
+		"Redis.addrs: failed on 'min=1'",
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors:\n%s", len(errs), err.Error())
+	}
+	for i, w := range want {
+		if !strings.HasPrefix(errs[i], w) {
+			t.Errorf("error %d: got %q, want prefix %q", i, errs[i], w)
+		}
+	}
+}
+
+func TestConfigSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv(SecretKeyEnv, "000102030405060708090a0b0c0d0e0f")
+	os.Setenv("TEST_REDIS_PWD", "redis-secret")
+	defer os.Unsetenv(SecretKeyEnv)
+	defer os.Unsetenv("TEST_REDIS_PWD")
+
+	enc, err := EncryptSecret("bucket-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "db"), "db-secret\n")
+	base := filepath.Join(dir, "api-dev.toml")
+	writeFile(t, base, `
+env = "dev"
+server_host = "0.0.0.0:8801"
+level = 1
+secret_key = "`+enc+`"
+[Db]
+addr = "127.0.0.1:3306"
+user = "root"
+pwd = "${file:`+filepath.Join(dir, "db")+`}"
+dbname = "test"
+[Redis]
+addrs = ["127.0.0.1:6379"]
+pwd = "${env:TEST_REDIS_PWD}"
+[[notifiers]]
+url = "https://robot/send?token=${env:TEST_REDIS_PWD}"
+[tokens]
+robot = "${env:TEST_REDIS_PWD}"
+`)
+	type notifier struct {
+		Url string `toml:"url"`
+	}
+	var c struct {
+		testConfig
+		SecretKey string            `toml:"secret_key"`
+		Notifiers []notifier        `toml:"notifiers"`
+		Tokens    map[string]string `toml:"tokens"`
+	}
+	if err := (&ConfigLoader{Path: base, Args: []string{}}).Load(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Db.Pwd != "db-secret" || c.Redis.Pwd != "redis-secret" || c.SecretKey != "bucket-secret" {
+		t.Fatalf("unexpected secrets %q %q %q", c.Db.Pwd, c.Redis.Pwd, c.SecretKey)
+	}
+	if c.Notifiers[0].Url != "https://robot/send?token=redis-secret" || c.Tokens["robot"] != "redis-secret" {
+		t.Fatalf("unexpected nested secrets %+v %v", c.Notifiers, c.Tokens)
+	}
+	errs := ResolveConfigSecrets(&struct {
+		Notifiers []notifier `toml:"notifiers"`
+	}{Notifiers: []notifier{{Url: "${env:TEST_NOT_SET}"}}})
+	if len(errs) != 1 || !strings.HasPrefix(errs[0], "notifiers[0].url: ") {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+
+	//解析出的值在输出时被遮蔽, 即使字段名不敏感
+	masked := MaskConfig(struct {
+		Dsn  string `toml:"dsn"`
+		Name string `toml:"name"`
+	}{Dsn: "root:db-secret@tcp(127.0.0.1:3306)/test", Name: "redis-secret"}).(map[string]interface{})
+	if masked["name"] != MaskedValue || strings.Contains(masked["dsn"].(string), "db-secret") {
+		t.Fatalf("unexpected masked %v", masked)
+	}
+	if s := MaskString("auth redis-secret failed"); s != "auth "+MaskedValue+" failed" {
+		t.Fatalf("unexpected masked string %q", s)
+	}
+
+	if _, err := ResolveSecret("${env:TEST_NOT_SET}"); err == nil {
+		t.Fatal("expect error for unset env")
+	}
+	os.Setenv(SecretKeyEnv, "000102030405060708090a0b0c0d0e0f1011121314151617")
+	if _, err := EncryptSecret("bucket-secret"); err == nil {
+		t.Fatal("expect error for 24 bytes key")
+	}
+}
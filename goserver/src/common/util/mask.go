@@ -0,0 +1,159 @@
+package util
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+//MaskedValue 敏感配置输出时的替代值
+const MaskedValue = "******"
+
+//sensitiveNames 字段名或 map key 包含这些词(不区分大小写)时视为敏感
+var sensitiveNames = []string{"pwd", "passw", "secret", "token", "private"}
+
+//dsnPassword user:password@tcp(host:port)/db 格式中的密码
+var dsnPassword = regexp.MustCompile(`^([^:@/]+):([^@/]+)@(tcp|unix)\(`)
+
+//IsSensitiveName 名称中包含 pwd/password/secret/token/private 时返回 true
+func IsSensitiveName(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range sensitiveNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+//MaskConfig 将配置转换为可 json 序列化的 map, 用于打印或通过管理端口输出
+//字段名取 toml 标签, 敏感字段与带 mask:"true" 标签的字段替换为 MaskedValue,
+//url 中的密码与敏感参数, mysql DSN 中的密码以及由 ResolveSecret 解析出的值也会被遮蔽, 未导出与 toml:"-" 的字段被忽略
+func MaskConfig(v interface{}) interface{} {
+	return maskValue(reflect.ValueOf(v), false)
+}
+
+func maskValue(v reflect.Value, sensitive bool) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return maskValue(v.Elem(), sensitive)
+
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(time.Time{}) {
+			return v.Interface()
+		}
+		t := v.Type()
+		out := make(map[string]interface{}, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name := f.Name
+			tag := strings.Split(f.Tag.Get("toml"), ",")[0]
+			if tag == "-" {
+				continue
+			} else if tag != "" {
+				name = tag
+			} else if f.Anonymous && f.Type.Kind() == reflect.Struct {
+				//匿名嵌入的结构体与 toml 一样展开到当前层
+				if sub, ok := maskValue(v.Field(i), sensitive).(map[string]interface{}); ok {
+					for k, sv := range sub {
+						out[k] = sv
+					}
+				}
+				continue
+			}
+			s := sensitive || f.Tag.Get("mask") == "true" || IsSensitiveName(name) || IsSensitiveName(f.Name)
+			out[name] = maskValue(v.Field(i), s)
+		}
+		return out
+
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		out := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			key := fmt.Sprint(k.Interface())
+			out[key] = maskValue(v.MapIndex(k), sensitive || IsSensitiveName(key))
+		}
+		return out
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		if sensitive && v.Type().Elem().Kind() == reflect.Uint8 {
+			return MaskedValue
+		}
+		out := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out[i] = maskValue(v.Index(i), sensitive)
+		}
+		return out
+
+	case reflect.String:
+		s := v.String()
+		if sensitive && s != "" || isSecret(s) {
+			return MaskedValue
+		}
+		return MaskString(s)
+
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return nil
+
+	default:
+		if sensitive {
+			return MaskedValue
+		}
+		return v.Interface()
+	}
+}
+
+//MaskString 遮蔽字符串中的 url 密码, 敏感的 url 参数, DSN 密码与已解析的密钥, 其余内容原样返回
+func MaskString(s string) string {
+	s = maskSecrets(s)
+	if dsnPassword.MatchString(s) {
+		return dsnPassword.ReplaceAllString(s, "${1}:"+MaskedValue+"@${3}(")
+	}
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return s
+	}
+	changed := false
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), MaskedValue)
+			changed = true
+		}
+	}
+	if u.RawQuery != "" {
+		q := u.Query()
+		for key := range q {
+			if IsSensitiveName(key) || strings.EqualFold(key, "sign") {
+				q.Set(key, MaskedValue)
+				changed = true
+			}
+		}
+		if changed {
+			u.RawQuery = q.Encode()
+		}
+	}
+	if !changed {
+		return s
+	}
+	return u.String()
+}
@@ -10,12 +10,12 @@ import (
 )
 
 type MysqlConfig struct {
-	Addr         string `toml:"addr"`
-	User         string `toml:"user"`
+	Addr         string `toml:"addr" validate:"required"`
+	User         string `toml:"user" validate:"required"`
 	Pwd          string `toml:"pwd"`
-	Dbname       string `toml:"dbname"`
-	MaxOpenConns int    `toml:"max_open_conns"`
-	MaxIdleConns int    `toml:"max_idle_conns"`
+	Dbname       string `toml:"dbname" validate:"required"`
+	MaxOpenConns int    `toml:"max_open_conns" validate:"gte=0"`
+	MaxIdleConns int    `toml:"max_idle_conns" validate:"gte=0"`
 }
 
 var ErrorInsertDuplicate = errors.New("insert duplicated")
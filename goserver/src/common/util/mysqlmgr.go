@@ -29,8 +29,15 @@ func NewGormDB(sqlDB *sql.DB) (*gorm.DB, error) {
 	return gormDB, err
 }
 
-// dbUser format: user:password
+// dbUser format: user:password, 其中的密钥引用解析失败时 panic
 func NewMysqlMgr(dbUser string, env string) *MysqlMgr {
+	if IsSecretRef(dbUser) {
+		resolved, err := ResolveSecret(dbUser)
+		if err != nil {
+			panic(fmt.Sprintf("NewMysqlMgr: ResolveSecret Error=%s", err.Error()))
+		}
+		dbUser = resolved
+	}
 	maxOpenConns := 10
 	maxIdleConns := 5
 	if env == defs.EnvProd {
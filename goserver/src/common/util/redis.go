@@ -11,9 +11,9 @@ import (
 )
 
 type RedisConfig struct {
-	Addrs    []string `toml:"addrs"`
+	Addrs    []string `toml:"addrs" validate:"min=1"`
 	Pwd      string   `toml:"pwd"`
-	PoolSize int      `toml:"pool_size"`
+	PoolSize int      `toml:"pool_size" validate:"gte=0"`
 }
 
 func NewRedisClient(c *RedisConfig) (*redis.Client, error) {
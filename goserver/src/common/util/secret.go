@@ -0,0 +1,210 @@
+package util
+
+//配置中的密钥引用, 加载配置时解析为实际的值:
+//	${file:/run/secrets/db}  读取文件内容, 去掉首尾空白
+//	${env:DB_PWD}            读取环境变量, 未设置时报错
+//	enc:base64               用本地密钥解密, 密文由 EncryptSecret 生成, 须为整个值
+//${...} 可以出现在值的任意位置, eg. NewMysqlMgr 的 "user:${env:DB_PWD}"
+//解析出的值会被记录, MaskConfig 与 MaskString 输出时将其替换为 MaskedValue
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+//SecretKeyEnv 本地密钥(hex 编码的 16 字节 AES 密钥, 与 Encrypt 的固定 IV 等长)所在的环境变量
+const SecretKeyEnv = "CONFIG_SECRET_KEY"
+
+//SecretKeyFileEnv 未设置 SecretKeyEnv 时, 从该环境变量指定的文件读取本地密钥
+const SecretKeyFileEnv = "CONFIG_SECRET_KEY_FILE"
+
+//_EncPrefix 加密值的前缀
+const _EncPrefix = "enc:"
+
+var secretRef = regexp.MustCompile(`\$\{(file|env):([^}]+)\}`)
+
+//secrets 已解析的密钥值, 用于输出时遮蔽
+var secrets sync.Map
+
+//_MinMaskLen MaskString 只替换不短于该长度的密钥, 避免过短的值遮蔽无关内容
+const _MinMaskLen = 4
+
+//IsSecretRef 值中包含密钥引用时返回 true
+func IsSecretRef(s string) bool {
+	return strings.HasPrefix(s, _EncPrefix) || secretRef.MatchString(s)
+}
+
+//ResolveSecret 解析值中的密钥引用, 不包含引用时原样返回
+func ResolveSecret(s string) (string, error) {
+	if strings.HasPrefix(s, _EncPrefix) {
+		key, err := secretKey()
+		if err != nil {
+			return "", err
+		}
+		b, err := Decrypt(strings.TrimPrefix(s, _EncPrefix), key)
+		if err != nil {
+			return "", fmt.Errorf("decrypt failed: %s", err.Error())
+		}
+		addSecret(string(b))
+		return string(b), nil
+	}
+
+	var firstErr error
+	resolved := secretRef.ReplaceAllStringFunc(s, func(ref string) string {
+		m := secretRef.FindStringSubmatch(ref)
+		var v string
+		switch m[1] {
+		case "file":
+			b, err := ioutil.ReadFile(m[2])
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return ref
+			}
+			v = strings.TrimSpace(string(b))
+		case "env":
+			var ok bool
+			if v, ok = os.LookupEnv(m[2]); !ok {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("env %s not set", m[2])
+				}
+				return ref
+			}
+		}
+		addSecret(v)
+		return v
+	})
+	if firstErr != nil {
+		return "", firstErr
+	}
+	return resolved, nil
+}
+
+//EncryptSecret 用本地密钥加密, 返回可写入配置文件的 enc:... 值
+func EncryptSecret(plaintext string) (string, error) {
+	key, err := secretKey()
+	if err != nil {
+		return "", err
+	}
+	s, err := Encrypt([]byte(plaintext), key)
+	if err != nil {
+		return "", err
+	}
+	return _EncPrefix + s, nil
+}
+
+func secretKey() (string, error) {
+	key := strings.TrimSpace(os.Getenv(SecretKeyEnv))
+	if key == "" {
+		path := os.Getenv(SecretKeyFileEnv)
+		if path == "" {
+			return "", fmt.Errorf("secret key not found, set %s or %s", SecretKeyEnv, SecretKeyFileEnv)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		key = strings.TrimSpace(string(b))
+	}
+	if len(key) != len(AES_IV)*2 {
+		return "", fmt.Errorf("secret key must be %d hex characters", len(AES_IV)*2)
+	}
+	return key, nil
+}
+
+func addSecret(v string) {
+	if v != "" {
+		secrets.Store(v, true)
+	}
+}
+
+//isSecret 值为已解析的密钥
+func isSecret(s string) bool {
+	_, ok := secrets.Load(s)
+	return ok
+}
+
+//maskSecrets 将值中出现的已解析密钥替换为 MaskedValue
+func maskSecrets(s string) string {
+	secrets.Range(func(k, _ interface{}) bool {
+		if secret := k.(string); len(secret) >= _MinMaskLen {
+			s = strings.Replace(s, secret, MaskedValue, -1)
+		}
+		return true
+	})
+	return s
+}
+
+//ResolveConfigSecrets 递归解析配置中所有字符串的密钥引用, 包括切片, map 以及其中的结构体, 返回所有错误
+func ResolveConfigSecrets(v interface{}) ConfigErrors {
+	var errs ConfigErrors
+	resolveValue(reflect.ValueOf(v), "", &errs)
+	return errs
+}
+
+//resolveValue 路径与 configFields 相同取 toml 标签, map 的值不可寻址, 复制解析后写回
+func resolveValue(v reflect.Value, path string, errs *ConfigErrors) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			resolveValue(v.Elem(), path, errs)
+		}
+	case reflect.String:
+		if err := resolveField(v); err != nil {
+			*errs = append(*errs, fmt.Sprintf("%s: %s", path, err.Error()))
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" && !f.Anonymous {
+				continue
+			}
+			name := strings.Split(f.Tag.Get("toml"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" && f.Anonymous {
+				resolveValue(v.Field(i), path, errs)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			resolveValue(v.Field(i), name, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			resolveValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(iter.Value())
+			resolveValue(e, fmt.Sprintf("%s.%v", path, iter.Key()), errs)
+			v.SetMapIndex(iter.Key(), e)
+		}
+	}
+}
+
+func resolveField(v reflect.Value) error {
+	if !IsSecretRef(v.String()) {
+		return nil
+	}
+	s, err := ResolveSecret(v.String())
+	if err != nil {
+		return err
+	}
+	v.SetString(s)
+	return nil
+}
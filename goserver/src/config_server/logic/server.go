@@ -1,10 +1,12 @@
 package logic
 
 import (
-	"common/metrics"
+	"common/alert"
+	"common/app"
+	"common/discovery"
 	"common/project"
 	"common/proto/base"
-	"common/tlog"
+	"common/proto/config"
 	"common/util"
 	"context"
 	"database/sql"
@@ -12,15 +14,13 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/go-redis/redis"
+	"google.golang.org/grpc"
 )
 
 type Config struct {
-	Log     tlog.Config      `toml:"Log"`
-	Etcd    []string         `toml:"etcd"`
-	Env     string           `toml:"env"`
-	EtcdEnv string           `toml:"etcd_env"`
-	Db      util.MysqlConfig `toml:"Db"`
-	Redis   util.RedisConfig `toml:"Redis"`
+	app.Config
+	Db    util.MysqlConfig `toml:"Db"`
+	Redis util.RedisConfig `toml:"Redis"`
 }
 
 type Server struct {
@@ -35,38 +35,45 @@ type Server struct {
 
 var ThisServer *Server
 
-func NewServer(c *Config) error {
-	serverName := "config"
-	metrics.Init(serverName, c.Env)
-
-	db, err := util.NewMysql(&c.Db)
-	if err != nil {
-		tlog.Fatal(err)
-		return err
-	}
-
-	cacheRedis, err := util.NewRedisClient(&c.Redis)
-	if err != nil {
-		tlog.Fatal(err)
-		return err
-	}
-	gormDB, err := util.NewGormDB(db)
-	if err != nil {
-		tlog.Fatal(err)
-		return err
+//NewServer 注册 config 服务的组件, 由 app.Run 按顺序启动
+func NewServer(a *app.App, c *Config) {
+	var db *sql.DB
+	var cacheRedis *redis.Client
+	//未配置 etcd 时不启用配置中心
+	if len(c.Etcd) > 0 {
+		a.Add(a.ConfCenter())
 	}
-
-	ThisServer = &Server{
-		Env:        c.Env,
-		EtcdEnv:    c.EtcdEnv,
-		Config:     c,
-		Mysql:      db,
-		CacheRedis: cacheRedis,
-		GormDB:     gormDB,
-		Dingding:   project.NewRobotDingDing(c.Env, serverName),
-	}
-
-	return nil
+	a.Add(
+		app.Features(),
+		app.Mysql("main", &c.Db, &db),
+		app.Redis("cache", &c.Redis, &cacheRedis),
+		&app.Component{
+			Name: "server",
+			Init: func(ctx context.Context) error {
+				gormDB, err := util.NewGormDB(db)
+				if err != nil {
+					return err
+				}
+				ThisServer = &Server{
+					Env:        c.Env,
+					EtcdEnv:    c.EtcdEnv,
+					Config:     c,
+					Mysql:      db,
+					CacheRedis: cacheRedis,
+					GormDB:     gormDB,
+					Dingding:   project.NewRobotDingDing(c.Env, a.Name(), c.Alert.Notifier(alert.TypeDingDing)),
+				}
+				return nil
+			},
+			Stop: func(ctx context.Context) error {
+				DestroyServer()
+				return nil
+			},
+		},
+		a.GRPCServer(discovery.ConfigServer, func(srv *grpc.Server) {
+			config.RegisterConfigServer(srv, ThisServer)
+		}),
+	)
 }
 
 func DestroyServer() {
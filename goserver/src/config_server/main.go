@@ -1,43 +1,26 @@
 package main
 
 import (
-	"common/discovery"
-	"common/tlog"
+	"common/app"
 	"common/util"
 	"config_server/logic"
 	"fmt"
-	"math/rand"
-	"runtime"
-	"time"
+	"os"
 )
 
 func main() {
 	var c logic.Config
-	if !util.NewConfig("./config-dev.toml", &c) {
-		return
+	//CONFIG_CONFIG_ENV=prod 时叠加 config-prod.toml, 环境变量 CONFIG_DB_PWD 等覆盖配置文件, 见 util.ConfigLoader
+	loader := &util.ConfigLoader{Path: "./config-dev.toml", EnvPrefix: "CONFIG_"}
+	if err := loader.Load(&c); err != nil {
+		fmt.Printf("config load failed: %s\n%s\n", loader.Path, err.Error())
+		os.Exit(2)
 	}
-	tlog.Init(c.Log)
 
-	if c.EtcdEnv == "" {
-		c.EtcdEnv = c.Env
-	}
-	discovery.Init(c.Etcd...)
-
-	runtime.GOMAXPROCS(runtime.NumCPU())
-	rand.Seed(time.Now().UnixNano())
-
-	var err error
-	if err = logic.NewServer(&c); err == nil {
-		if err = discovery.RegisterConfigServer(c.EtcdEnv, logic.ThisServer); err == nil {
-			fmt.Println(util.FormatFullTime(time.Now()), "running ...")
-			discovery.WaitForClose()
-		}
-		logic.DestroyServer()
-	}
-	if err != nil {
-		discovery.Close()
+	a := app.New("config", &c.Config, &c)
+	logic.NewServer(a, &c)
+	if err := a.Run(); err != nil {
 		fmt.Println(err)
+		os.Exit(1)
 	}
-
-	tlog.Close()
 }